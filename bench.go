@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"serveur/ranking"
+)
+
+// bases de données jetables utilisées par la commande bench-leaderboard
+const (
+	benchDatabase        = "spotTrendQuizzerBench"
+	benchSpotifyDatabase = "spotifyDataBench"
+)
+
+// remplit un classement de test avec users joueurs puis mesure les requêtes du classement
+// (top 5 et classement autour d'un joueur en tête, au milieu et en bas, pour chaque méthode de
+// classement), en les comparant au chargement complet de la collection que faisait l'ancienne
+// implémentation. Les index sont ceux de production : les migrations sont appliquées aux bases
+// jetables avant l'insertion des joueurs.
+func benchLeaderboard(ctx context.Context, users, repeat int, keep bool) error {
+	client, err := connectToMongo()
+	if err != nil {
+		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	//les migrations et les lectures visent les bases jetables le temps de la mesure
+	previousQuizzer, previousSpotify := quizzerDatabase, spotifyDatabase
+	quizzerDatabase, spotifyDatabase = benchDatabase, benchSpotifyDatabase
+	defer func() { quizzerDatabase, spotifyDatabase = previousQuizzer, previousSpotify }()
+
+	db, spotify := quizzerDB(client), spotifyDB(client)
+	for _, bench := range []*mongo.Database{db, spotify} {
+		if err := bench.Drop(ctx); err != nil {
+			return fmt.Errorf("erreur lors du vidage de la base de test %s: %w", bench.Name(), err)
+		}
+		if !keep {
+			defer bench.Drop(context.Background())
+		}
+	}
+	if err := migrateUp(ctx, client, 0); err != nil {
+		return fmt.Errorf("erreur lors de l'application des migrations à la base de test: %w", err)
+	}
+	classement := db.Collection("classement")
+
+	if err := seedBenchLeaderboard(ctx, classement, users); err != nil {
+		return err
+	}
+	//les joueurs sont insérés directement : leurs groupes d'ex æquo sont construits ensuite
+	if err := buildScoreGroups(ctx, db); err != nil {
+		return err
+	}
+	groups, err := scoreGroupsCollection(db).CountDocuments(ctx, allTimeBoard(db).groupFilter(bson.M{}))
+	if err != nil {
+		return fmt.Errorf("erreur lors du comptage des groupes de scores: %w", err)
+	}
+
+	measure := func(name string, fn func() error) error {
+		start := time.Now()
+		for i := 0; i < repeat; i++ {
+			if err := fn(); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		fmt.Printf("%-48s %12s\n", name, (time.Since(start) / time.Duration(repeat)).String())
+		return nil
+	}
+
+	fmt.Printf("%d joueurs, %d groupes d'ex æquo, moyenne sur %d exécutions\n", users, groups, repeat)
+	if err := measure("top 5", func() error {
+		_, err := getTopPlayers(ctx, board{collection: classement})
+		return err
+	}); err != nil {
+		return err
+	}
+
+	//le joueur du bas est le cas le plus coûteux : chaque comptage parcourt tout le classement
+	//(ou tous les groupes d'ex æquo, en rang dense) de la tête jusqu'à lui
+	configured := rankingConfig
+	defer func() { rankingConfig = configured }()
+	for _, method := range []ranking.Method{ranking.Dense, ranking.Competition, ranking.Ordinal} {
+		rankingConfig = ranking.Config{Method: method, TieBreakers: configured.TieBreakers}
+		for _, userID := range []string{"bench-top", "bench-middle", "bench-bottom"} {
+			userID := userID
+			if err := measure(fmt.Sprintf("classement autour de %s (%s)", userID, method), func() error {
+				_, err := getRanking(ctx, board{collection: classement}, userID)
+				return err
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return measure("chargement complet (ancien)", func() error {
+		cursor, err := classement.Find(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		var all []UserRanking
+		return cursor.All(ctx, &all)
+	})
+}
+
+// insère les joueurs par lots avec des scores aléatoires, plus trois joueurs repères
+func seedBenchLeaderboard(ctx context.Context, classement *mongo.Collection, users int) error {
+	const batchSize = 10000
+	rng := rand.New(rand.NewSource(1))
+	batch := make([]interface{}, 0, batchSize)
+	for i := 0; i < users; i++ {
+		batch = append(batch, UserRanking{
+			UserID:          fmt.Sprintf("bench-%08d", i),
+			Pseudo:          fmt.Sprintf("joueur%d", i),
+			Score:           rng.Intn(50000),
+			NbDeParties:     rng.Intn(200),
+			ScoreAchievedAt: time.Unix(rng.Int63n(1<<31), 0).UTC(),
+		})
+		if len(batch) == batchSize || i == users-1 {
+			if _, err := classement.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+				return fmt.Errorf("erreur lors de l'insertion des joueurs de test: %w", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	markers := []interface{}{
+		UserRanking{UserID: "bench-top", Pseudo: "repere-haut", Score: 1000000},
+		UserRanking{UserID: "bench-middle", Pseudo: "repere-milieu", Score: 25000},
+		UserRanking{UserID: "bench-bottom", Pseudo: "repere-bas", Score: -1},
+	}
+	if _, err := classement.InsertMany(ctx, markers); err != nil {
+		return fmt.Errorf("erreur lors de l'insertion des joueurs repères: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sous-commande lancée via `serveur <nom>` au lieu de démarrer le serveur
+type command struct {
+	description string
+	run         func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"pseudo-conflicts": {
+		description: "liste les pseudonymes qui ne diffèrent que par la casse",
+		run: func(ctx context.Context, args []string) error {
+			return reportPseudoConflicts(ctx)
+		},
+	},
+	"bench-leaderboard": {
+		description: "mesure les requêtes du classement sur une base de test (-users, -repeat, -keep)",
+		run: func(ctx context.Context, args []string) error {
+			flags := flag.NewFlagSet("bench-leaderboard", flag.ContinueOnError)
+			users := flags.Int("users", 1000000, "nombre de joueurs à insérer")
+			repeat := flags.Int("repeat", 20, "nombre d'exécutions de chaque requête")
+			keep := flags.Bool("keep", false, "conserve les bases "+benchDatabase+" et "+benchSpotifyDatabase+" après la mesure")
+			if err := flags.Parse(args); err != nil {
+				return err
+			}
+			return benchLeaderboard(ctx, *users, *repeat, *keep)
+		},
+	},
+	"migrate": {
+		description: "gère les migrations de schéma : up [version], down [nombre], status",
+		run:         runMigrateCommand,
+	},
+	"season": {
+		description: "gère les saisons : create -id -name -start -end, rollover",
+		run:         runSeasonCommand,
+	},
+	"rebuild-score-groups": {
+		description: "reconstruit les groupes d'ex æquo utilisés pour le rang dense",
+		run: func(ctx context.Context, args []string) error {
+			return rebuildScoreGroups(ctx)
+		},
+	},
+	"reconcile-scores": {
+		description: "répare les écarts de score entre users et classement (-dry-run pour seulement les lister)",
+		run: func(ctx context.Context, args []string) error {
+			flags := flag.NewFlagSet("reconcile-scores", flag.ContinueOnError)
+			dryRun := flags.Bool("dry-run", false, "liste les écarts sans les corriger")
+			if err := flags.Parse(args); err != nil {
+				return err
+			}
+			return reconcileScores(ctx, *dryRun)
+		},
+	},
+}
+
+// exécute la sous-commande demandée et renvoie le code de sortie du processus
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "commande inconnue: %s\n\ncommandes disponibles:\n", args[0])
+		for name, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, c.description)
+		}
+		return 2
+	}
+
+	if err := cmd.run(context.Background(), args[1:]); err != nil {
+		slog.Error("la commande a échoué", "command", args[0], "error", err)
+		return 1
+	}
+	return 0
+}
+
+// sous-commande migrate up [version] | down [nombre] | status
+func runMigrateCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up [version] | down [nombre] | status")
+	}
+	number := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("nombre invalide: %s", args[1])
+		}
+		number = n
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	switch args[0] {
+	case "up":
+		return migrateUp(ctx, client, number)
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+		return migrateDown(ctx, client, number)
+	case "status":
+		states, err := migrationStatus(ctx, client)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			appliedAt := "en attente"
+			if state.AppliedAt != nil {
+				appliedAt = state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%3d  %-25s  %s\n", state.Version, appliedAt, state.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("action inconnue: %s", args[0])
+	}
+}
+
+// sous-commande season create -id -name -start -end | rollover
+func runSeasonCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: season create -id <id> -name <nom> -start <date> -end <date> | rollover")
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("season create", flag.ContinueOnError)
+		id := flags.String("id", "", "identifiant de la saison")
+		name := flags.String("name", "", "nom affiché de la saison")
+		start := flags.String("start", "", "début de la saison (2006-01-02 ou RFC 3339, UTC)")
+		end := flags.String("end", "", "fin de la saison, exclue (2006-01-02 ou RFC 3339, UTC)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		startsAt, err := parseSeasonTime(*start)
+		if err != nil {
+			return err
+		}
+		endsAt, err := parseSeasonTime(*end)
+		if err != nil {
+			return err
+		}
+		return createSeason(ctx, quizzerDB(client), season{ID: *id, Name: *name, StartsAt: startsAt, EndsAt: endsAt}, time.Now())
+	case "rollover":
+		return rollOverSeasons(ctx, quizzerDB(client), time.Now())
+	default:
+		return fmt.Errorf("action inconnue: %s", args[0])
+	}
+}
+
+// lit une date de début ou de fin de saison
+func parseSeasonTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date invalide: %q", value)
+	}
+	return t.UTC(), nil
+}
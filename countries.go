@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// taille maximale acceptée pour le corps JSON d'une mise à jour du profil
+const maxProfileBodyBytes = 1 << 10
+
+// pays que les joueurs peuvent choisir : les marchés dont on récupère le Top 50
+func knownCountries() []string {
+	playlists := createTOP50Playlists()
+	countries := make([]string, 0, len(playlists))
+	for _, playlist := range playlists {
+		countries = append(countries, playlist.Country)
+	}
+	return countries
+}
+
+// renvoie le nom du pays tel qu'enregistré, quelle que soit la casse demandée
+func normalizeCountry(country string) (string, bool) {
+	for _, known := range knownCountries() {
+		if strings.EqualFold(strings.TrimSpace(country), known) {
+			return known, true
+		}
+	}
+	return "", false
+}
+
+// vérifie un pays choisi par le joueur ; une chaîne vide signifie « aucun pays »
+func validateCountry(field, country string) (string, *fieldError) {
+	if country == "" {
+		return "", nil
+	}
+	normalized, ok := normalizeCountry(country)
+	if !ok {
+		return "", &fieldError{Field: field, Code: "enum", Message: "Doit être l'un des pays suivants : " + strings.Join(knownCountries(), ", ")}
+	}
+	return normalized, nil
+}
+
+// lit le paramètre country de la requête (tous les pays par défaut)
+func countryFromRequest(r *http.Request) (string, *fieldError) {
+	return validateCountry("country", r.URL.Query().Get("country"))
+}
+
+// restreint le classement aux joueurs du pays donné (aucune restriction si vide)
+func (b board) inCountry(country string) board {
+	if country == "" {
+		return b
+	}
+	scope := bson.M{"country": country}
+	for k, v := range b.scope {
+		scope[k] = v
+	}
+	return board{collection: b.collection, scope: scope}
+}
+
+// opérateur de mise à jour qui enregistre le pays, ou le retire s'il est vide
+func countryUpdate(country string) bson.M {
+	if country == "" {
+		return bson.M{"$unset": bson.M{"country": ""}}
+	}
+	return bson.M{"$set": bson.M{"country": country}}
+}
+
+// change le pays de l'utilisateur dans users, classement et les classements des périodes en
+// cours ; les périodes passées et les saisons archivées gardent le pays de l'époque
+func updateUserCountry(ctx context.Context, client *mongo.Client, userID, country string) error {
+	db := quizzerDB(client)
+	return newTransactionRunner(client).runInTransaction(ctx, func(ctx context.Context) error {
+		result, err := db.Collection("users").UpdateOne(ctx, bson.M{"userId": userID}, countryUpdate(country))
+		if err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du pays de l'utilisateur: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		if err := moveCountry(ctx, allTimeBoard(db), userID, country); err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du pays dans le classement: %w", err)
+		}
+
+		now := time.Now()
+		for _, kind := range timedPeriods {
+			p, err := resolvePeriod(ctx, db, period{Kind: kind}, now)
+			if err != nil {
+				return err
+			}
+			if err := moveCountry(ctx, periodBoard(db, p), userID, country); err != nil {
+				return fmt.Errorf("erreur lors de la mise à jour du pays dans le classement %s %s: %w", p.Kind, p.Key, err)
+			}
+		}
+		return nil
+	})
+}
+
+// change le pays du joueur dans le classement et déplace ses groupes d'ex æquo ; sans effet si
+// le joueur n'y figure pas
+func moveCountry(ctx context.Context, b board, userID, country string) error {
+	var before UserRanking
+	err := b.collection.FindOneAndUpdate(ctx, b.filter(bson.M{"userId": userID}), countryUpdate(country)).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	after := before
+	after.Country = country
+	return updateScoreGroups(ctx, b, &before, &after)
+}
+
+// renvoie les meilleurs joueurs de chaque pays pour le classement donné
+func getTopPlayersByCountry(ctx context.Context, b board) (map[string][]UserRanking, error) {
+	topPlayers := make(map[string][]UserRanking)
+	for _, country := range knownCountries() {
+		players, err := getTopPlayers(ctx, b.inCountry(country))
+		if err != nil {
+			return nil, err
+		}
+		topPlayers[country] = players
+	}
+	return topPlayers, nil
+}
+
+// corps de la requête de mise à jour du profil
+type updateProfileRequest struct {
+	Country *string `json:"country"`
+}
+
+// --------------- Handler gérant le profil ---------------------
+
+// handler pour modifier le profil de l'utilisateur connecté (pour l'instant, son pays)
+func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateProfileRequest
+	if !decodeJSONBody(w, r, &req, maxProfileBodyBytes) {
+		return
+	}
+	if req.Country == nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Aucun champ à modifier", []fieldError{{"country", "required", "Le pays est requis (\"\" pour le retirer)"}})
+		return
+	}
+	country, ferr := validateCountry("country", *req.Country)
+	if ferr != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Les données du profil sont invalides", []fieldError{*ferr})
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	err = updateUserCountry(r.Context(), client, userID, country)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		writeError(w, http.StatusNotFound, codeUserNotFound, "Utilisateur non trouvé", nil)
+		return
+	} else if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la mise à jour du profil", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la mise à jour du profil", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Profil mis à jour"})
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"serveur/ranking"
+)
+
+// Défi du jour : les mêmes questions pour tous les joueurs, une seule tentative chacun.
+//
+//	daily_challenges : { _id: "2006-01-02", seed, questions, generatedAt }
+//	daily_attempts   : { _id: "2006-01-02:userId", date, userId, pseudo, country, answers, correct, score, submittedAt }
+//
+// À minuit UTC, le planificateur met à jour les Top 50 et les artistes puis génère le défi à
+// partir de ce relevé du jour, avec une graine dérivée de la date ; le défi est figé dans
+// daily_challenges pour qu'une mise à jour faite en cours de journée (au redémarrage du serveur)
+// ne le change pas. Le classement du jour est propre au défi et n'alimente pas le classement
+// général.
+
+const (
+	dailyQuestionCount = 5
+	// points gagnés par bonne réponse à une question facile
+	dailyPointsPerAnswer = 10
+	// nombre maximal de questions générées pour obtenir dailyQuestionCount questions différentes
+	maxDailyQuestionAttempts = 3 * dailyQuestionCount
+	defaultDailyTop          = 10
+	maxDailyTop              = 100
+	maxDailyBodyBytes        = 4 << 10
+)
+
+// défi d'un jour, réponses comprises
+type dailyChallenge struct {
+	Date        string          `bson:"_id"`
+	Seed        int64           `bson:"seed"`
+	Questions   []QuestionTrend `bson:"questions"`
+	GeneratedAt time.Time       `bson:"generatedAt"`
+}
+
+// question du défi telle qu'envoyée au joueur ; Answer n'est renseigné qu'après sa tentative
+type dailyQuestion struct {
+	Type     string   `json:"type"`
+	Question string   `json:"question"`
+	Choices  []string `json:"choices"`
+	Answer   string   `json:"answer,omitempty"`
+}
+
+// tentative d'un joueur au défi du jour
+type dailyAttempt struct {
+	ID          string    `bson:"_id" json:"-"`
+	Date        string    `bson:"date" json:"date"`
+	UserID      string    `bson:"userId" json:"userId"`
+	Pseudo      string    `bson:"pseudo" json:"pseudo"`
+	Country     string    `bson:"country,omitempty" json:"country,omitempty"`
+	Answers     []string  `bson:"answers" json:"answers"`
+	Correct     int       `bson:"correct" json:"correct"`
+	Score       int       `bson:"score" json:"score"`
+	SubmittedAt time.Time `bson:"submittedAt" json:"submittedAt"`
+	Rank        int       `bson:"-" json:"rank,omitempty"`
+}
+
+// clé du défi du jour contenant t
+func dailyKey(t time.Time) string {
+	return periodKey(periodDaily, t)
+}
+
+// graine du défi d'une date : la même pour tous les joueurs et toutes les instances
+func dailySeed(date string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("daily:" + date))
+	return int64(h.Sum64())
+}
+
+// identifiant de la tentative d'un joueur : un seul document possible par joueur et par jour
+func dailyAttemptID(date, userID string) string {
+	return date + ":" + userID
+}
+
+// génère les questions du défi d'une date ; une question déjà tirée ou dont la génération échoue
+// est remplacée par une autre, dans la limite de maxDailyQuestionAttempts
+func generateDailyChallenge(ctx context.Context, store *mongo.Database, date string, now time.Time) (dailyChallenge, error) {
+	seed := dailySeed(date)
+	rng := rand.New(rand.NewSource(seed))
+	challenge := dailyChallenge{Date: date, Seed: seed, GeneratedAt: now.UTC()}
+
+	seen := make(map[string]bool)
+	var lastErr error
+	for attempt := 0; attempt < maxDailyQuestionAttempts && len(challenge.Questions) < dailyQuestionCount; attempt++ {
+		question, err := generateQuestion(ctx, store, quizModes[defaultQuizMode], rng, generationOptions{})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if seen[question.Question] {
+			continue
+		}
+		seen[question.Question] = true
+		challenge.Questions = append(challenge.Questions, question)
+	}
+	if len(challenge.Questions) < dailyQuestionCount {
+		err := fmt.Errorf("seulement %d questions différentes générées pour le défi du %s", len(challenge.Questions), date)
+		if lastErr != nil {
+			err = fmt.Errorf("%w: %w", err, lastErr)
+		}
+		return dailyChallenge{}, err
+	}
+	return challenge, nil
+}
+
+// renvoie le défi d'une date, en le générant s'il n'existe pas encore ; si deux instances le
+// génèrent en même temps, la première insertion l'emporte
+func ensureDailyChallenge(ctx context.Context, client *mongo.Client, date string, now time.Time) (dailyChallenge, error) {
+	challenges := quizzerDB(client).Collection("daily_challenges")
+	var challenge dailyChallenge
+	err := challenges.FindOne(ctx, bson.M{"_id": date}).Decode(&challenge)
+	if err == nil {
+		return challenge, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return dailyChallenge{}, fmt.Errorf("erreur lors de la lecture du défi du %s: %w", date, err)
+	}
+
+	challenge, err = generateDailyChallenge(ctx, spotifyDB(client), date, now)
+	if err != nil {
+		return dailyChallenge{}, err
+	}
+	if _, err := challenges.InsertOne(ctx, challenge); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return dailyChallenge{}, fmt.Errorf("erreur lors de l'enregistrement du défi du %s: %w", date, err)
+		}
+		if err := challenges.FindOne(ctx, bson.M{"_id": date}).Decode(&challenge); err != nil {
+			return dailyChallenge{}, fmt.Errorf("erreur lors de la lecture du défi du %s: %w", date, err)
+		}
+	}
+	slog.Info("défi du jour généré", "date", date)
+	return challenge, nil
+}
+
+// génère le défi du jour au démarrage puis à chaque minuit UTC, jusqu'à l'annulation du contexte ;
+// à minuit, refresh met d'abord à jour les données Spotify pour que le défi porte sur le relevé du jour
+func runDailyChallengeScheduler(ctx context.Context, refresh func()) {
+	generate := func() {
+		client, err := connectToMongo()
+		if err != nil {
+			slog.Error("erreur lors de la connexion à MongoDB", "error", err)
+			return
+		}
+		defer client.Disconnect(context.Background())
+
+		now := time.Now()
+		if _, err := ensureDailyChallenge(ctx, client, dailyKey(now), now); err != nil {
+			slog.Error("erreur lors de la génération du défi du jour", "error", err)
+		}
+	}
+
+	generate()
+	for {
+		now := time.Now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		timer := time.NewTimer(midnight.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			refresh()
+			generate()
+		}
+	}
+}
+
+// corrige les réponses du joueur ; chaque bonne réponse rapporte dailyPointsPerAnswer multipliés
+// selon la difficulté de la question
+func scoreDailyAnswers(challenge dailyChallenge, answers []string) (correct, score int) {
+	for i, question := range challenge.Questions {
+		if i < len(answers) && answers[i] == question.Answer {
+			correct++
+			score += scaledPoints(dailyPointsPerAnswer, question.Difficulty)
+		}
+	}
+	return correct, score
+}
+
+// questions du défi telles qu'envoyées au joueur ; les réponses ne sont révélées qu'après sa tentative
+func dailyQuestions(challenge dailyChallenge, revealAnswers bool) []dailyQuestion {
+	questions := make([]dailyQuestion, 0, len(challenge.Questions))
+	for _, question := range challenge.Questions {
+		q := dailyQuestion{Type: question.Type, Question: question.Question, Choices: question.Choices}
+		if revealAnswers {
+			q.Answer = question.Answer
+		}
+		questions = append(questions, q)
+	}
+	return questions
+}
+
+// classement du défi d'une date : les meilleurs scores, puis les tentatives les plus anciennes
+func dailyLeaderboard(ctx context.Context, db *mongo.Database, date string, limit int) ([]dailyAttempt, error) {
+	cursor, err := db.Collection("daily_attempts").Find(ctx, bson.M{"date": date}, options.Find().
+		SetSort(bson.D{{Key: "score", Value: -1}, {Key: "submittedAt", Value: 1}, {Key: "userId", Value: 1}}).
+		SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture du classement du défi du %s: %w", date, err)
+	}
+	attempts := []dailyAttempt{}
+	if err = cursor.All(ctx, &attempts); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture du classement du défi du %s: %w", date, err)
+	}
+
+	//la date de la tentative départage les ex æquo, comme la date du score au classement général
+	config := ranking.Config{Method: rankingConfig.Method, TieBreakers: []ranking.TieBreaker{ranking.EarlierScore}}
+	entries := make([]ranking.Entry, len(attempts))
+	for i, attempt := range attempts {
+		entries[i] = ranking.Entry{ID: attempt.UserID, Score: attempt.Score, AchievedAt: attempt.SubmittedAt}
+	}
+	for i, rank := range config.Assign(entries, ranking.Top) {
+		attempts[i].Rank = rank
+	}
+	return attempts, nil
+}
+
+// corps de la réponse au défi du jour
+type dailyAnswerRequest struct {
+	Date    string   `json:"date"`
+	Answers []string `json:"answers"`
+}
+
+// correction d'une question du défi
+type dailyAnswerResult struct {
+	Answer        string `json:"answer"`
+	CorrectAnswer string `json:"correctAnswer"`
+	Correct       bool   `json:"correct"`
+}
+
+// --------------- Handlers gérant le défi du jour ---------------------
+
+// handler qui renvoie le défi du jour, sans les réponses tant que l'utilisateur ne l'a pas tenté
+func dailyChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	now := time.Now()
+	challenge, err := ensureDailyChallenge(r.Context(), client, dailyKey(now), now)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du défi du jour", "error", err)
+		writeError(w, http.StatusServiceUnavailable, codeQuestionUnavailable, "Le défi du jour n'est pas disponible", nil)
+		return
+	}
+
+	var attempt *dailyAttempt
+	var found dailyAttempt
+	err = quizzerDB(client).Collection("daily_attempts").FindOne(r.Context(), bson.M{"date": challenge.Date, "userId": userID}).Decode(&found)
+	if err == nil {
+		attempt = &found
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération de la tentative", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération de la tentative", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Date      string          `json:"date"`
+		Questions []dailyQuestion `json:"questions"`
+		Attempt   *dailyAttempt   `json:"attempt"`
+	}{challenge.Date, dailyQuestions(challenge, attempt != nil), attempt})
+}
+
+// handler qui enregistre l'unique tentative de l'utilisateur au défi du jour
+func dailyAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req dailyAnswerRequest
+	if !decodeJSONBody(w, r, &req, maxDailyBodyBytes) {
+		return
+	}
+	now := time.Now()
+	today := dailyKey(now)
+	if req.Date != "" && req.Date != today {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "La réponse est invalide", []fieldError{{"date", "expired", "Seul le défi du jour (" + today + ") peut être tenté"}})
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	challenge, err := ensureDailyChallenge(r.Context(), client, today, now)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du défi du jour", "error", err)
+		writeError(w, http.StatusServiceUnavailable, codeQuestionUnavailable, "Le défi du jour n'est pas disponible", nil)
+		return
+	}
+	if len(req.Answers) != len(challenge.Questions) {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "La réponse est invalide", []fieldError{{"answers", "length", fmt.Sprintf("Exactement %d réponses attendues", len(challenge.Questions))}})
+		return
+	}
+
+	db := quizzerDB(client)
+	var user User
+	if err := db.Collection("users").FindOne(r.Context(), bson.M{"userId": userID}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			writeError(w, http.StatusNotFound, codeUserNotFound, "Utilisateur non trouvé", nil)
+			return
+		}
+		loggerFrom(r.Context()).Error("erreur lors de la récupération de l'utilisateur", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération de l'utilisateur", nil)
+		return
+	}
+
+	correct, score := scoreDailyAnswers(challenge, req.Answers)
+	attempt := dailyAttempt{
+		ID:          dailyAttemptID(challenge.Date, userID),
+		Date:        challenge.Date,
+		UserID:      userID,
+		Pseudo:      user.Pseudo,
+		Country:     user.Country,
+		Answers:     req.Answers,
+		Correct:     correct,
+		Score:       score,
+		SubmittedAt: now.UTC(),
+	}
+	//l'identifiant (date, userId) garantit une seule tentative, même en cas de requêtes simultanées
+	//et sans compter sur l'index unique de la migration 11
+	if _, err := db.Collection("daily_attempts").InsertOne(r.Context(), attempt); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			writeError(w, http.StatusConflict, codeAlreadyPlayed, "Le défi du jour a déjà été tenté", nil)
+			return
+		}
+		loggerFrom(r.Context()).Error("erreur lors de l'enregistrement de la tentative", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de l'enregistrement de la tentative", nil)
+		return
+	}
+
+	results := make([]dailyAnswerResult, len(challenge.Questions))
+	for i, question := range challenge.Questions {
+		results[i] = dailyAnswerResult{Answer: req.Answers[i], CorrectAnswer: question.Answer, Correct: req.Answers[i] == question.Answer}
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		dailyAttempt
+		Results []dailyAnswerResult `json:"results"`
+	}{attempt, results})
+}
+
+// handler qui renvoie le classement du défi d'un jour (aujourd'hui par défaut)
+func dailyLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	var errs []fieldError
+	limit, ferr := intQueryParam(r, "limit", defaultDailyTop, 1, maxDailyTop)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = dailyKey(time.Now())
+	} else if _, err := time.Parse("2006-01-02", date); err != nil {
+		errs = append(errs, fieldError{Field: "date", Code: "format", Message: "Date attendue au format 2006-01-02"})
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	attempts, err := dailyLeaderboard(r.Context(), quizzerDB(client), date, limit)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du classement du défi", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement du défi", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Date    string         `json:"date"`
+		Players []dailyAttempt `json:"players"`
+	}{date, attempts})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Chaque question porte une difficulté calculée à partir de son contenu (écart de popularité
+// entre les choix, notoriété des artistes, positions dans les Top 50). La difficulté allonge le
+// temps de réponse et multiplie les points. Le mode adaptive vise la difficulté qui correspond
+// à la justesse récente du joueur.
+
+const (
+	adaptiveQuizMode = "adaptive"
+	// popularité Spotify en dessous de laquelle un artiste est considéré comme peu connu
+	obscurePopularity = 50
+	// nombre de réponses récentes prises en compte par le mode adaptive
+	adaptiveWindow = 20
+	// nombre minimal de réponses avant d'adapter la difficulté
+	minAdaptiveAnswers = 5
+	// nombre de questions générées pour approcher la difficulté visée
+	adaptiveCandidates = 5
+)
+
+// multiplicateur des points selon la difficulté
+var difficultyMultipliers = map[int]float64{
+	difficultyEasy:   1,
+	difficultyMedium: 1.5,
+	difficultyHard:   2,
+}
+
+// ramène une difficulté entre difficultyEasy et difficultyHard
+func clampDifficulty(d int) int {
+	if d < difficultyEasy {
+		return difficultyEasy
+	}
+	if d > difficultyHard {
+		return difficultyHard
+	}
+	return d
+}
+
+// points de base multipliés selon la difficulté
+func scaledPoints(points, difficulty int) int {
+	multiplier, ok := difficultyMultipliers[difficulty]
+	if !ok {
+		multiplier = 1
+	}
+	return int(math.Round(float64(points) * multiplier))
+}
+
+// difficulté d'une question de popularité : plus l'écart entre la réponse et le choix le plus
+// proche est faible, plus elle est difficile ; une réponse peu connue la rend plus difficile
+func popularityGapDifficulty(gap, answerPopularity int) int {
+	d := difficultyHard
+	switch {
+	case gap >= 20:
+		d = difficultyEasy
+	case gap >= 10:
+		d = difficultyMedium
+	}
+	if answerPopularity < obscurePopularity {
+		d++
+	}
+	return clampDifficulty(d)
+}
+
+// difficulté d'une question sur des artistes selon leur popularité moyenne
+func obscurityDifficulty(artists []Artist) int {
+	if len(artists) == 0 {
+		return difficultyMedium
+	}
+	total := 0
+	for _, artist := range artists {
+		total += artist.Popularity
+	}
+	switch average := total / len(artists); {
+	case average >= 70:
+		return difficultyEasy
+	case average >= obscurePopularity:
+		return difficultyMedium
+	default:
+		return difficultyHard
+	}
+}
+
+// difficulté d'une question « dans quel pays » : une piste bien classée est plus facile, une
+// piste classée presque aussi haut dans un autre pays l'est moins
+func chartCountryDifficulty(best, runnerUp int) int {
+	d := difficultyHard
+	switch {
+	case best <= 10:
+		d = difficultyEasy
+	case best <= 25:
+		d = difficultyMedium
+	}
+	if runnerUp > 0 && runnerUp-best < 5 {
+		d++
+	}
+	return clampDifficulty(d)
+}
+
+// difficulté d'une question « quelle piste » selon la meilleure position parmi les autres choix
+func chartTrackDifficulty(closestPosition int) int {
+	switch {
+	case closestPosition <= 3:
+		return difficultyHard
+	case closestPosition <= 10:
+		return difficultyMedium
+	default:
+		return difficultyEasy
+	}
+}
+
+// difficulté visée pour un joueur selon la justesse de ses dernières réponses chronométrées
+func targetDifficulty(ctx context.Context, db *mongo.Database, userID string) (int, error) {
+	if userID == "" {
+		return difficultyMedium, nil
+	}
+	cursor, err := db.Collection("issued_questions").Find(ctx,
+		bson.M{"userId": userID, "answeredAt": bson.M{"$exists": true}},
+		options.Find().
+			SetSort(bson.D{{Key: "answeredAt", Value: -1}}).
+			SetLimit(adaptiveWindow).
+			SetProjection(bson.M{"correct": 1}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("erreur lors de la lecture des réponses récentes: %w", err)
+	}
+	var answers []struct {
+		Correct bool `bson:"correct"`
+	}
+	if err = cursor.All(ctx, &answers); err != nil {
+		return 0, fmt.Errorf("erreur lors de la lecture des réponses récentes: %w", err)
+	}
+
+	correct := 0
+	for _, answer := range answers {
+		if answer.Correct {
+			correct++
+		}
+	}
+	return difficultyForAccuracy(correct, len(answers)), nil
+}
+
+// difficulté correspondant à une justesse : difficile au-delà de 80 %, facile en dessous de 50 %
+func difficultyForAccuracy(correct, answered int) int {
+	if answered < minAdaptiveAnswers {
+		return difficultyMedium
+	}
+	switch accuracy := float64(correct) / float64(answered); {
+	case accuracy >= 0.8:
+		return difficultyHard
+	case accuracy >= 0.5:
+		return difficultyMedium
+	default:
+		return difficultyEasy
+	}
+}
+
+// génère plusieurs questions et garde la première de la difficulté visée, ou à défaut la plus proche
+func generateQuestionNear(ctx context.Context, store *mongo.Database, weights generatorWeights, target int, rng *rand.Rand, opts generationOptions) (QuestionTrend, error) {
+	var best QuestionTrend
+	found := false
+	var lastErr error
+	for i := 0; i < adaptiveCandidates; i++ {
+		question, err := generateQuestion(ctx, store, weights, rng, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if question.Difficulty == target {
+			return question, nil
+		}
+		if !found || abs(question.Difficulty-target) < abs(best.Difficulty-target) {
+			best, found = question, true
+		}
+	}
+	if !found {
+		return QuestionTrend{}, lastErr
+	}
+	return best, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
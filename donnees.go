@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -47,6 +47,7 @@ type Track struct {
 	Popularity int      `json:"popularity"`
 	Artists    []string `json:"artists"`
 	Country    string   `json:"country"`
+	Position   int      `json:"position"` // position dans le Top 50 du pays, à partir de 1
 }
 
 type PlaylistCountry struct {
@@ -100,13 +101,14 @@ func extractArtistNameAndPopularity(artistsData []interface{}) ([]string, []Arti
 		}
 		artistID, _ := artistMap["id"].(string)
 		artistName, _ := artistMap["name"].(string)
-		artistPopularity, _ := artistMap["popularity"].(int)
+		//les nombres JSON sont décodés en float64
+		artistPopularity, _ := artistMap["popularity"].(float64)
 
 		artistNames = append(artistNames, artistName)
 		artistDetails = append(artistDetails, Artist{
 			ID:         artistID,
 			Name:       artistName,
-			Popularity: artistPopularity,
+			Popularity: int(artistPopularity),
 		})
 	}
 	return artistNames, artistDetails
@@ -154,7 +156,7 @@ func saveTracksFromPlaylist(playlistID string, country string) ([]Track, error)
 	}
 	defer client.Disconnect(context.TODO())
 
-	artistsCollection := client.Database("spotifyData").Collection("artists")
+	artistsCollection := spotifyDB(client).Collection("artists")
 
 	items, ok := result["items"].([]interface{})
 	if !ok {
@@ -162,7 +164,7 @@ func saveTracksFromPlaylist(playlistID string, country string) ([]Track, error)
 	}
 
 	//parcourt la liste de tracks et extrait les différentes données pour les sauvegarder dans la collection correspondante
-	for _, item := range items {
+	for i, item := range items {
 		trackMap, ok := item.(map[string]interface{})["track"].(map[string]interface{})
 		if !ok {
 			continue
@@ -175,6 +177,7 @@ func saveTracksFromPlaylist(playlistID string, country string) ([]Track, error)
 			Popularity: int(trackMap["popularity"].(float64)),
 			Artists:    artistNames,
 			Country:    country,
+			Position:   i + 1,
 		}
 
 		tracks = append(tracks, track)
@@ -194,7 +197,7 @@ func saveTop50Playlists(playlists []PlaylistCountry) error {
 	}
 	defer client.Disconnect(context.TODO())
 
-	top50Collection := client.Database("spotifyData").Collection("top50")
+	top50Collection := spotifyDB(client).Collection("top50")
 	//vide la collection 'top50' avant l'insertion des nouveaux documents
 	_, err = top50Collection.DeleteMany(context.Background(), bson.M{})
 	if err != nil {
@@ -205,7 +208,7 @@ func saveTop50Playlists(playlists []PlaylistCountry) error {
 	for _, pc := range playlists {
 		tracks, err := saveTracksFromPlaylist(pc.PlaylistID, pc.Country)
 		if err != nil {
-			log.Printf("Erreur lors de la récupération des tracks pour le pays %s: %v", pc.Country, err)
+			slog.Error("erreur lors de la récupération des tracks", "country", pc.Country, "error", err)
 			continue
 		}
 
@@ -218,7 +221,7 @@ func saveTop50Playlists(playlists []PlaylistCountry) error {
 		// insére le document dans la collection 'top50'
 		_, err = top50Collection.InsertOne(context.Background(), countryTracks)
 		if err != nil {
-			log.Printf("Erreur lors de l'insertion des tracks pour le pays %s dans la collection top50: %v", pc.Country, err)
+			slog.Error("erreur lors de l'insertion des tracks dans la collection top50", "country", pc.Country, "error", err)
 		}
 	}
 
@@ -234,7 +237,7 @@ func updateArtistsPopularityAndGenre() error {
 	}
 	defer client.Disconnect(context.TODO())
 
-	artistsCollection := client.Database("spotifyData").Collection("artists")
+	artistsCollection := spotifyDB(client).Collection("artists")
 
 	cursor, err := artistsCollection.Find(context.TODO(), bson.M{})
 	if err != nil {
@@ -258,21 +261,21 @@ func updateArtistsPopularityAndGenre() error {
 
 		req, err := http.NewRequest("GET", artistURL, nil)
 		if err != nil {
-			log.Printf("erreur lors de la création de la requête pour l'artiste %s: %v", artist.ID, err)
+			slog.Warn("erreur lors de la création de la requête pour l'artiste", "artist_id", artist.ID, "error", err)
 			continue
 		}
 		req.Header.Set("Authorization", "Bearer "+token)
 
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			log.Printf("erreur lors de l'envoi de la requête pour l'artiste %s: %v", artist.ID, err)
+			slog.Warn("erreur lors de l'envoi de la requête pour l'artiste", "artist_id", artist.ID, "error", err)
 			continue
 		}
 		defer resp.Body.Close()
 
 		responseBody, err := io.ReadAll(resp.Body)
 		if err != nil {
-			log.Printf("erreur lors de la lecture de la réponse pour l'artiste %s: %v", artist.ID, err)
+			slog.Warn("erreur lors de la lecture de la réponse pour l'artiste", "artist_id", artist.ID, "error", err)
 			continue
 		}
 
@@ -281,7 +284,7 @@ func updateArtistsPopularityAndGenre() error {
 			Genres     []string `json:"genres"`
 		}
 		if err := json.Unmarshal(responseBody, &spotifyArtist); err != nil {
-			log.Printf("Erreur lors du décodage de la réponse pour l'artiste %s: %v", artist.ID, err)
+			slog.Warn("erreur lors du décodage de la réponse pour l'artiste", "artist_id", artist.ID, "error", err)
 			continue
 		}
 
@@ -295,10 +298,10 @@ func updateArtistsPopularityAndGenre() error {
 		filter := bson.M{"id": artist.ID}
 
 		if _, err := artistsCollection.UpdateOne(context.TODO(), filter, update); err != nil {
-			log.Printf("Erreur lors de la mise à jour de l'artiste %s: %v", artist.ID, err)
+			slog.Warn("erreur lors de la mise à jour de l'artiste", "artist_id", artist.ID, "error", err)
 		}
 	}
-	fmt.Println("updateArtistsPopularityAndGenre is done!")
+	slog.Info("mise à jour des artistes terminée", "count", len(artists))
 
 	return nil
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// codes d'erreur renvoyés aux clients, stables et indépendants de la langue du message
+const (
+	codeInvalidJSON         = "invalid_json"
+	codeBodyTooLarge        = "body_too_large"
+	codeValidationFailed    = "validation_failed"
+	codePseudoTaken         = "pseudo_taken"
+	codeInvalidCredentials  = "invalid_credentials"
+	codeMissingToken        = "missing_token"
+	codeInvalidToken        = "invalid_token"
+	codeUserNotFound        = "user_not_found"
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeOriginNotAllowed    = "origin_not_allowed"
+	codeDatabaseError       = "database_error"
+	codeQuestionUnavailable = "question_unavailable"
+	codeAlreadyPlayed       = "already_played"
+	codeAlreadyAnswered     = "already_answered"
+	codeQuestionExpired     = "question_expired"
+	codeInternalError       = "internal_error"
+)
+
+// corps JSON commun à toutes les réponses d'erreur
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details"`
+}
+
+// écrit une réponse JSON avec le statut donné
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// écrit une erreur au format {code, message, details}
+func writeError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	writeJSON(w, status, apiError{Code: code, Message: message, Details: details})
+}
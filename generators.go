@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Chaque type de question est un QuestionGenerator qui s'enregistre lui-même dans un init() :
+// ajouter un type de question ne demande pas de modifier le handler. Les modes de quiz
+// (?mode=) pondèrent le tirage des générateurs.
+//
+// La génération est déterministe : tout le hasard vient du rng passé aux générateurs et les
+// documents sont lus dans un ordre stable puis tirés avec ce rng (jamais avec $sample), si bien
+// qu'une même graine sur un même jeu de données donne toujours la même question.
+
+// niveaux de difficulté des générateurs
+const (
+	difficultyEasy = iota + 1
+	difficultyMedium
+	difficultyHard
+)
+
+// nombre maximal de générateurs essayés pour une même question
+const maxQuestionAttempts = 3
+
+// générateur d'un type de question
+type QuestionGenerator interface {
+	// identifiant stable, utilisé dans les modes de quiz, les métriques et QuestionTrend.Type
+	ID() string
+	// nom affiché du type de question
+	Name() string
+	// difficulté par défaut, de difficultyEasy à difficultyHard, des questions dont le
+	// générateur ne calcule pas la difficulté
+	Difficulty() int
+	// génère une question à partir des données Spotify ; tout tirage aléatoire passe par rng
+	Generate(ctx context.Context, store *mongo.Database, rng *rand.Rand, opts generationOptions) (QuestionTrend, error)
+}
+
+// options de génération transmises à chaque générateur
+type generationOptions struct {
+	// questions vues récemment par le joueur, dont les artistes et pistes sont à éviter ; nil
+	// lorsque la question n'est pas générée pour un joueur (anonyme, graine choisie, défi du jour)
+	Recent *recentQuestions
+}
+
+var questionGenerators = make(map[string]QuestionGenerator)
+
+// métriques publiées par expvar (GET /api/v1/metrics), par identifiant de générateur
+var (
+	questionAttempts = expvar.NewMap("question_generator_attempts")
+	questionFailures = expvar.NewMap("question_generator_failures")
+)
+
+// handler de GET /api/v1/metrics : ne publie que les métriques des générateurs, et non tout
+// expvar (ligne de commande, mémoire) qui n'a rien à faire sur l'API publique
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %s, %q: %s}\n",
+		"question_generator_attempts", questionAttempts.String(),
+		"question_generator_failures", questionFailures.String(),
+	)
+}
+
+// enregistre un générateur ; appelé depuis les init(), un identifiant en double est une erreur de programmation
+func registerGenerator(g QuestionGenerator) {
+	id := g.ID()
+	if id == "" {
+		panic("générateur de question sans identifiant")
+	}
+	if _, exists := questionGenerators[id]; exists {
+		panic(fmt.Sprintf("générateur de question %q enregistré deux fois", id))
+	}
+	questionGenerators[id] = g
+}
+
+// identifiants des générateurs enregistrés, triés pour que le tirage ne dépende pas de l'ordre des maps
+func generatorIDs() []string {
+	ids := make([]string, 0, len(questionGenerators))
+	for id := range questionGenerators {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// poids de chaque générateur dans un mode de quiz ; un générateur absent n'est jamais tiré
+type generatorWeights map[string]int
+
+// modes de quiz par défaut ; classic et adaptive (nil) tirent tous les générateurs enregistrés
+// à poids égal, adaptive choisissant en plus la difficulté selon le joueur
+var quizModes = map[string]generatorWeights{
+	defaultQuizMode:  nil,
+	adaptiveQuizMode: nil,
+	"artists":        {"top-artists": 2, "genre": 1},
+	"charts":         {"regional-trends": 1},
+}
+
+// noms des modes de quiz, triés
+func quizModeNames() []string {
+	names := make([]string, 0, len(quizModes))
+	for name := range quizModes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lit QUIZ_MODES, de la forme « mode=générateur:poids,générateur:poids;mode=... », qui ajoute ou
+// remplace des modes de quiz ; garde les modes par défaut si la configuration est invalide
+func loadQuizModes() {
+	raw := strings.TrimSpace(os.Getenv("QUIZ_MODES"))
+	if raw == "" {
+		return
+	}
+	modes, err := parseQuizModes(raw)
+	if err != nil {
+		slog.Error("configuration des modes de quiz ignorée", "error", err)
+		return
+	}
+	for name, weights := range modes {
+		quizModes[name] = weights
+	}
+}
+
+// analyse la valeur de QUIZ_MODES
+func parseQuizModes(raw string) (map[string]generatorWeights, error) {
+	modes := make(map[string]generatorWeights)
+	for _, rawMode := range strings.Split(raw, ";") {
+		name, rawWeights, ok := strings.Cut(strings.TrimSpace(rawMode), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("mode de quiz invalide: %q", rawMode)
+		}
+		weights := make(generatorWeights)
+		for _, rawWeight := range strings.Split(rawWeights, ",") {
+			id, rawValue, ok := strings.Cut(strings.TrimSpace(rawWeight), ":")
+			id = strings.TrimSpace(id)
+			if !ok {
+				return nil, fmt.Errorf("poids invalide dans le mode %s: %q", name, rawWeight)
+			}
+			if _, known := questionGenerators[id]; !known {
+				return nil, fmt.Errorf("générateur inconnu dans le mode %s: %q", name, id)
+			}
+			weight, err := strconv.Atoi(strings.TrimSpace(rawValue))
+			if err != nil || weight < 0 {
+				return nil, fmt.Errorf("poids invalide pour %s dans le mode %s: %q", id, name, rawValue)
+			}
+			weights[id] = weight
+		}
+		modes[name] = weights
+	}
+	return modes, nil
+}
+
+// tire un générateur selon les poids (tous à 1 si weights est nil), en ignorant ceux de excluded
+func pickGenerator(weights generatorWeights, excluded map[string]bool, rng *rand.Rand) (QuestionGenerator, bool) {
+	var candidates []QuestionGenerator
+	var cumulative []int
+	total := 0
+	for _, id := range generatorIDs() {
+		weight := 1
+		if weights != nil {
+			weight = weights[id]
+		}
+		if weight <= 0 || excluded[id] {
+			continue
+		}
+		total += weight
+		candidates = append(candidates, questionGenerators[id])
+		cumulative = append(cumulative, total)
+	}
+	if total == 0 {
+		return nil, false
+	}
+	n := rng.Intn(total)
+	i := sort.SearchInts(cumulative, n+1)
+	return candidates[i], true
+}
+
+// génère une question avec un générateur tiré selon les poids ; un générateur qui échoue n'est
+// pas retenté pour cette question et l'échec est compté dans ses métriques
+func generateQuestion(ctx context.Context, store *mongo.Database, weights generatorWeights, rng *rand.Rand, opts generationOptions) (QuestionTrend, error) {
+	excluded := make(map[string]bool)
+	var errs []error
+	for attempt := 0; attempt < maxQuestionAttempts; attempt++ {
+		g, ok := pickGenerator(weights, excluded, rng)
+		if !ok {
+			break
+		}
+		questionAttempts.Add(g.ID(), 1)
+		question, err := g.Generate(ctx, store, rng, opts)
+		if err == nil && len(question.Choices) == 0 {
+			err = errors.New("question sans choix")
+		}
+		if err != nil {
+			questionFailures.Add(g.ID(), 1)
+			loggerFrom(ctx).Warn("échec de génération de question", "generator", g.ID(), "attempt", attempt+1, "error", err)
+			excluded[g.ID()] = true
+			errs = append(errs, fmt.Errorf("%s: %w", g.ID(), err))
+			continue
+		}
+		question.Type = g.ID()
+		if question.Difficulty == 0 {
+			question.Difficulty = g.Difficulty()
+		}
+		return question, nil
+	}
+	if len(errs) == 0 {
+		return QuestionTrend{}, errors.New("aucun générateur de question disponible pour ce mode")
+	}
+	return QuestionTrend{}, errors.Join(errs...)
+}
+
+// tire au plus n artistes distincts correspondant au filtre, de façon déterministe : les
+// identifiants Spotify sont lus triés, tirés avec rng, puis les artistes sont relus et renvoyés
+// dans l'ordre du tirage ; les artistes de recent (vus récemment par le joueur) sont évités
+func sampleArtists(ctx context.Context, db *mongo.Database, filter bson.M, n int, rng *rand.Rand, recent *recentQuestions) ([]Artist, error) {
+	artists := db.Collection("artists")
+	cursor, err := artists.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{"_id": 0, "id": 1}).
+		SetSort(bson.D{{Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des identifiants d'artistes: %w", err)
+	}
+	var rows []struct {
+		ID string `bson:"id"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des identifiants d'artistes: %w", err)
+	}
+
+	//tirage sans remise des n premiers éléments (Fisher-Yates partiel)
+	//écarte les artistes vus récemment par le joueur, sauf s'il n'en reste pas assez
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if !recent.seenEntity(artistEntity(row.ID)) {
+			ids = append(ids, row.ID)
+		}
+	}
+	if len(ids) < n {
+		ids = ids[:0]
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+	}
+	if n > len(ids) {
+		n = len(ids)
+	}
+	for i := 0; i < n; i++ {
+		j := i + rng.Intn(len(ids)-i)
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	ids = ids[:n]
+	if n == 0 {
+		return nil, nil
+	}
+
+	cursor, err = artists.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des artistes: %w", err)
+	}
+	var found []Artist
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des artistes: %w", err)
+	}
+	byID := make(map[string]Artist, len(found))
+	for _, artist := range found {
+		byID[artist.ID] = artist
+	}
+	sampled := make([]Artist, 0, n)
+	for _, id := range ids {
+		if artist, ok := byID[id]; ok {
+			sampled = append(sampled, artist)
+		}
+	}
+	return sampled, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Les genres Spotify sont très fins (« french hip hop », « dance pop », « k-pop »...). Les
+// questions de genre portent sur des familles : un genre appartient à la famille dont le nom
+// termine son nom, mot pour mot (« french hip hop » → « hip hop », « k-pop » → « pop »). Un
+// genre qui ne termine par aucune famille connue forme sa propre famille.
+
+// familles de genres connues ; quand plusieurs conviennent, la plus longue l'emporte
+// (« pop rock » → « rock », « dance pop » → « pop »)
+var genreFamilies = []string{
+	"hip hop", "r&b", "pop", "rock", "rap", "jazz", "blues", "classical", "metal", "soul",
+	"funk", "country", "folk", "reggae", "reggaeton", "house", "techno", "trap", "edm", "indie",
+}
+
+// nombre minimal d'artistes d'une famille pour qu'elle puisse être la réponse d'une question
+const minGenreFamilyArtists = 3
+
+// famille de genres, avec les genres Spotify qui la composent et leur nombre d'artistes
+type genreGroup struct {
+	Family  string
+	Genres  []string
+	Artists int
+}
+
+// renvoie la famille du genre : la famille connue la plus longue qui termine le nom du genre
+// sur une frontière de mot (espace ou tiret), sinon le genre lui-même
+func genreFamily(genre string) string {
+	genre = strings.ToLower(strings.TrimSpace(genre))
+	best := ""
+	for _, family := range genreFamilies {
+		if len(family) <= len(best) {
+			continue
+		}
+		if genre == family {
+			return family
+		}
+		if strings.HasSuffix(genre, family) {
+			if sep := genre[len(genre)-len(family)-1]; sep == ' ' || sep == '-' {
+				best = family
+			}
+		}
+	}
+	if best == "" {
+		return genre
+	}
+	return best
+}
+
+// familles distinctes d'un artiste
+func artistFamilies(artist Artist) map[string]bool {
+	families := make(map[string]bool)
+	for _, genre := range artist.Genre {
+		if genre != "" {
+			families[genreFamily(genre)] = true
+		}
+	}
+	return families
+}
+
+// regroupe en familles le nombre d'artistes de chaque genre, de la famille la plus fréquente à la
+// moins fréquente ; un artiste ayant plusieurs genres d'une même famille y est compté plusieurs fois
+func buildGenrePool(counts map[string]int) []genreGroup {
+	byFamily := make(map[string]*genreGroup)
+	for genre, count := range counts {
+		if genre == "" || count <= 0 {
+			continue
+		}
+		family := genreFamily(genre)
+		group, ok := byFamily[family]
+		if !ok {
+			group = &genreGroup{Family: family}
+			byFamily[family] = group
+		}
+		group.Genres = append(group.Genres, genre)
+		group.Artists += count
+	}
+
+	pool := make([]genreGroup, 0, len(byFamily))
+	for _, group := range byFamily {
+		sort.Strings(group.Genres)
+		pool = append(pool, *group)
+	}
+	sort.Slice(pool, func(i, j int) bool {
+		if pool[i].Artists != pool[j].Artists {
+			return pool[i].Artists > pool[j].Artists
+		}
+		return pool[i].Family < pool[j].Family
+	})
+	return pool
+}
+
+// lit le nombre d'artistes de chaque genre dans la collection artists et les regroupe en familles
+func loadGenrePool(ctx context.Context, db *mongo.Database) ([]genreGroup, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$genre"}},
+		{{Key: "$group", Value: bson.M{"_id": "$genre", "artists": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := db.Collection("artists").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du comptage des genres: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Genre   string `bson:"_id"`
+		Artists int    `bson:"artists"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des genres: %w", err)
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Genre] = row.Artists
+	}
+	return buildGenrePool(counts), nil
+}
+
+// familles pouvant être la réponse, tirées au sort proportionnellement à leur nombre d'artistes
+func pickAnswerFamilies(pool []genreGroup, rng *rand.Rand) []genreGroup {
+	var eligible []genreGroup
+	for _, group := range pool {
+		if group.Artists >= minGenreFamilyArtists {
+			eligible = append(eligible, group)
+		}
+	}
+
+	//tirage pondéré sans remise : chaque famille reçoit la clé u^(1/poids), u uniforme dans [0, 1)
+	keys := make(map[string]float64, len(eligible))
+	for _, group := range eligible {
+		keys[group.Family] = math.Pow(rng.Float64(), 1/float64(group.Artists))
+	}
+	sort.SliceStable(eligible, func(i, j int) bool { return keys[eligible[i].Family] > keys[eligible[j].Family] })
+	return eligible
+}
+
+// construit la question : les 3 premiers artistes appartiennent à la famille answer, le dernier
+// non ; les autres choix sont des familles du pool présentes chez moins de 3 des 4 artistes,
+// pour que la réponse soit la seule famille partagée par 3 artistes
+func buildGenreQuestion(answer string, artists []Artist, pool []genreGroup, rng *rand.Rand) (QuestionTrend, error) {
+	if len(artists) != 4 {
+		return QuestionTrend{}, fmt.Errorf("4 artistes attendus pour une question de genre, %d reçus", len(artists))
+	}
+	shared := make(map[string]int)
+	for i, artist := range artists {
+		families := artistFamilies(artist)
+		if families[answer] != (i < 3) {
+			return QuestionTrend{}, fmt.Errorf("l'artiste %q ne correspond pas à la famille %q attendue", artist.Name, answer)
+		}
+		for family := range families {
+			shared[family]++
+		}
+	}
+
+	//une famille partagée par autant d'artistes que la réponse ne peut pas être un choix
+	candidates := make([]string, 0, len(pool))
+	for _, group := range pool {
+		if group.Family != answer && shared[group.Family] < shared[answer] {
+			candidates = append(candidates, group.Family)
+		}
+	}
+	if len(candidates) < 3 {
+		return QuestionTrend{}, fmt.Errorf("pas assez de familles de genres pour proposer 4 choix")
+	}
+	rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+
+	//la famille de l'intrus figure parmi les choix quand c'est possible, pour que la question ne soit pas triviale
+	choices := []string{answer}
+	chosen := map[string]bool{answer: true}
+	for _, family := range candidates {
+		if artistFamilies(artists[3])[family] {
+			choices = append(choices, family)
+			chosen[family] = true
+			break
+		}
+	}
+	for _, family := range candidates {
+		if len(choices) == 4 {
+			break
+		}
+		if !chosen[family] {
+			choices = append(choices, family)
+			chosen[family] = true
+		}
+	}
+	rng.Shuffle(len(choices), func(i, j int) { choices[i], choices[j] = choices[j], choices[i] })
+
+	entities := make([]string, 0, len(artists))
+	for _, artist := range artists {
+		entities = append(entities, artistEntity(artist.ID))
+	}
+	shuffled := append([]Artist(nil), artists...)
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return QuestionTrend{
+		Question: fmt.Sprintf("Quel est le genre musical le plus représenté parmi ces artistes : %s,  %s,  %s,  %s",
+			shuffled[0].Name, shuffled[1].Name, shuffled[2].Name, shuffled[3].Name),
+		Choices:    choices,
+		Answer:     answer,
+		Genre:      answer,
+		Difficulty: obscurityDifficulty(artists),
+		Entities:   entities,
+	}, nil
+}
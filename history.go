@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Historique complet des parties. Chaque quiz terminé est enregistré dans quiz_results :
+//
+//	quiz_results : { _id, userId, playedAt, score, questionCount, answers, durationMs, mode, rankAfter }
+//
+// rankAfter est le rang de l'utilisateur au classement de tous les temps après la partie.
+//
+// users.scoreHistory garde les 5 derniers scores (en chaînes) pour les anciens clients.
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+	defaultQuizMode     = "classic"
+	maxQuizModeLength   = 32
+	maxQuizQuestions    = 100
+
+	// taille maximale acceptée pour le corps JSON d'un quiz terminé (100 réponses et questions)
+	maxQuizResultBodyBytes = 16 << 10
+)
+
+// réponse à une question d'un quiz terminé
+type questionOutcome struct {
+	Type    string `bson:"type,omitempty" json:"type,omitempty"`
+	Genre   string `bson:"genre,omitempty" json:"genre,omitempty"`
+	Correct bool   `bson:"correct" json:"correct"`
+}
+
+// partie enregistrée dans quiz_results
+type quizRecord struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        string             `bson:"userId" json:"userId"`
+	PlayedAt      time.Time          `bson:"playedAt" json:"playedAt"`
+	Score         int                `bson:"score" json:"score"`
+	QuestionCount int                `bson:"questionCount" json:"questionCount"`
+	Answers       []questionOutcome  `bson:"answers" json:"answers"`
+	DurationMs    int64              `bson:"durationMs" json:"durationMs"`
+	Mode          string             `bson:"mode" json:"mode"`
+	RankAfter     int                `bson:"rankAfter,omitempty" json:"rankAfter,omitempty"`
+}
+
+// vérifie les informations facultatives d'un quiz terminé et complète les valeurs par défaut
+func (result *QuizResult) validate() []fieldError {
+	var errs []fieldError
+	if result.QuestionCount < 0 || result.QuestionCount > maxQuizQuestions {
+		errs = append(errs, fieldError{"questionCount", "range", fmt.Sprintf("Doit être un entier entre 0 et %d", maxQuizQuestions)})
+	}
+	if len(result.Answers) > maxQuizQuestions {
+		errs = append(errs, fieldError{"answers", "length", fmt.Sprintf("Au plus %d réponses", maxQuizQuestions)})
+	} else if result.QuestionCount > 0 && len(result.Answers) > result.QuestionCount {
+		errs = append(errs, fieldError{"answers", "length", "Plus de réponses que de questions"})
+	}
+	if result.DurationMs < 0 {
+		errs = append(errs, fieldError{"durationMs", "range", "Doit être positif"})
+	}
+	if len(result.QuestionIDs) > maxQuizQuestions {
+		errs = append(errs, fieldError{"questionIds", "length", fmt.Sprintf("Au plus %d questions", maxQuizQuestions)})
+	}
+	for _, id := range result.QuestionIDs {
+		if !primitive.IsValidObjectID(id) {
+			errs = append(errs, fieldError{"questionIds", "invalid", "Identifiant de question invalide: " + id})
+			break
+		}
+	}
+	if len(result.Mode) > maxQuizModeLength {
+		errs = append(errs, fieldError{"mode", "length", fmt.Sprintf("Au plus %d caractères", maxQuizModeLength)})
+	}
+
+	if result.QuestionCount == 0 {
+		result.QuestionCount = len(result.Answers)
+	}
+	if result.Mode == "" {
+		result.Mode = defaultQuizMode
+	}
+
+	return errs
+}
+
+// enregistre la partie dans quiz_results
+func insertQuizRecord(ctx context.Context, db *mongo.Database, userID string, result QuizResult, playedAt time.Time, rankAfter int) error {
+	answers := result.Answers
+	if answers == nil {
+		answers = []questionOutcome{}
+	}
+	record := quizRecord{
+		UserID:        userID,
+		PlayedAt:      playedAt.UTC(),
+		Score:         result.Score,
+		QuestionCount: result.QuestionCount,
+		Answers:       answers,
+		DurationMs:    result.DurationMs,
+		Mode:          result.Mode,
+		RankAfter:     rankAfter,
+	}
+	if _, err := db.Collection("quiz_results").InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement de la partie: %w", err)
+	}
+	return nil
+}
+
+// position dans l'historique, encodée dans le curseur de pagination
+type historyCursor struct {
+	PlayedAt int64  `json:"t"`
+	ID       string `json:"i"`
+}
+
+func encodeHistoryCursor(record quizRecord) string {
+	b, _ := json.Marshal(historyCursor{PlayedAt: record.PlayedAt.UnixMilli(), ID: record.ID.Hex()})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// filtre des parties jouées avant la position encodée dans le curseur
+func decodeHistoryCursor(raw string) (bson.M, error) {
+	var cursor historyCursor
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(cursor.ID)
+	if err != nil {
+		return nil, errors.New("curseur incomplet")
+	}
+	playedAt := time.UnixMilli(cursor.PlayedAt).UTC()
+	return bson.M{"$or": bson.A{
+		bson.M{"playedAt": bson.M{"$lt": playedAt}},
+		bson.M{"playedAt": playedAt, "_id": bson.M{"$lt": id}},
+	}}, nil
+}
+
+// lit un paramètre de date (2006-01-02 ou RFC 3339) ; une date seule désigne minuit UTC
+func timeQueryParam(r *http.Request, name string) (*time.Time, *fieldError) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", raw); err != nil {
+			return nil, &fieldError{Field: name, Code: "format", Message: "Date attendue au format 2006-01-02 ou RFC 3339"}
+		}
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// page de l'historique des parties
+type historyPage struct {
+	Results    []quizRecord `json:"results"`
+	Limit      int          `json:"limit"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// --------------- Handler gérant l'historique des parties ---------------------
+
+// handler qui renvoie les parties de l'utilisateur connecté, de la plus récente à la plus
+// ancienne, entre from (inclus) et to (exclu)
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var errs []fieldError
+	limit, ferr := intQueryParam(r, "limit", defaultHistoryLimit, 1, maxHistoryLimit)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	from, ferr := timeQueryParam(r, "from")
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	to, ferr := timeQueryParam(r, "to")
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		errs = append(errs, fieldError{Field: "to", Code: "range", Message: "to doit être postérieur à from"})
+	}
+
+	filter := bson.M{"userId": userID}
+	playedAt := bson.M{}
+	if from != nil {
+		playedAt["$gte"] = *from
+	}
+	if to != nil {
+		playedAt["$lt"] = *to
+	}
+	if len(playedAt) > 0 {
+		filter["playedAt"] = playedAt
+	}
+	if rawCursor := r.URL.Query().Get("cursor"); rawCursor != "" {
+		position, err := decodeHistoryCursor(rawCursor)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "cursor", Code: "invalid", Message: "Curseur invalide"})
+		} else {
+			filter = bson.M{"$and": bson.A{filter, position}}
+		}
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	results := []quizRecord{}
+	cursor, err := quizzerDB(client).Collection("quiz_results").Find(r.Context(), filter,
+		options.Find().
+			SetSort(bson.D{{Key: "playedAt", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit)),
+	)
+	if err == nil {
+		err = cursor.All(r.Context(), &results)
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération de l'historique", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération de l'historique", nil)
+		return
+	}
+
+	page := historyPage{Results: results, Limit: limit}
+	if len(results) == limit {
+		page.NextCursor = encodeHistoryCursor(results[len(results)-1])
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collation insensible à la casse : "Alice" et "alice" désignent le même pseudonyme
+var pseudoCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// groupe de comptes dont les pseudonymes ne diffèrent que par la casse
+type pseudoConflict struct {
+	Key     string   `bson:"_id"`
+	Pseudos []string `bson:"pseudos"`
+	UserIDs []string `bson:"userIds"`
+}
+
+// liste les pseudonymes existants qui entreraient en conflit avec l'index unique insensible à la casse
+func findPseudoConflicts(ctx context.Context, db *mongo.Database) ([]pseudoConflict, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$toLower", Value: "$pseudo"}}},
+			{Key: "pseudos", Value: bson.D{{Key: "$push", Value: "$pseudo"}}},
+			{Key: "userIds", Value: bson.D{{Key: "$push", Value: "$userId"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := db.Collection("users").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la recherche des pseudonymes en conflit: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var conflicts []pseudoConflict
+	if err = cursor.All(ctx, &conflicts); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des pseudonymes en conflit: %w", err)
+	}
+	return conflicts, nil
+}
+
+// affiche les pseudonymes en conflit, à lancer avant le déploiement de l'index unique
+func reportPseudoConflicts(ctx context.Context) error {
+	client, err := connectToMongo()
+	if err != nil {
+		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	conflicts, err := findPseudoConflicts(ctx, quizzerDB(client))
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("aucun pseudonyme en conflit")
+		return nil
+	}
+	for _, conflict := range conflicts {
+		fmt.Printf("%s\t%v\t%v\n", conflict.Key, conflict.Pseudos, conflict.UserIDs)
+	}
+	return fmt.Errorf("%d pseudonymes en conflit à résoudre avant la création de l'index unique", len(conflicts))
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"serveur/ranking"
+)
+
+const (
+	defaultLeaderboardLimit = 20
+	maxLeaderboardLimit     = 100
+	defaultAroundMeRadius   = 2
+	maxAroundMeRadius       = 25
+)
+
+// méthode de classement et critères de départage, lus au démarrage par loadRankingConfig ;
+// par défaut les joueurs de même score partagent un rang dense
+var rankingConfig = ranking.Config{Method: ranking.Dense}
+
+// lit RANKING_METHOD (dense, competition, ordinal) et RANKING_TIEBREAKERS
+// (liste de earlier-score et fewer-games, séparés par des virgules) ; garde la
+// configuration par défaut si l'une des deux est invalide
+func loadRankingConfig() {
+	method, err := ranking.ParseMethod(os.Getenv("RANKING_METHOD"))
+	if err != nil {
+		slog.Error("configuration du classement ignorée", "error", err)
+		return
+	}
+	tieBreakers, err := ranking.ParseTieBreakers(os.Getenv("RANKING_TIEBREAKERS"))
+	if err != nil {
+		slog.Error("configuration du classement ignorée", "error", err)
+		return
+	}
+	rankingConfig = ranking.Config{Method: method, TieBreakers: tieBreakers}
+}
+
+// classement lu dans une collection, éventuellement restreint à une partie de ses documents
+// (une période de period_scores, une saison archivée) ; les documents ont la forme de UserRanking
+type board struct {
+	collection *mongo.Collection
+	scope      bson.M
+}
+
+// classement de tous les temps
+func allTimeBoard(db *mongo.Database) board {
+	return board{collection: db.Collection("classement")}
+}
+
+// ajoute la restriction du classement au filtre donné
+func (b board) filter(filter bson.M) bson.M {
+	if len(b.scope) == 0 {
+		return filter
+	}
+	scoped := bson.M{}
+	for k, v := range b.scope {
+		scoped[k] = v
+	}
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	return scoped
+}
+
+// champ de la collection classement utilisé pour trier les joueurs
+type sortKey struct {
+	Field string
+	Desc  bool
+}
+
+// critères qui distinguent deux rangs : le score puis les critères de départage configurés
+func rankingKeys() []sortKey {
+	keys := []sortKey{{Field: "scoreTotal", Desc: true}}
+	for _, tb := range rankingConfig.TieBreakers {
+		switch tb {
+		case ranking.EarlierScore:
+			keys = append(keys, sortKey{Field: "scoreAchievedAt"})
+		case ranking.FewerGames:
+			keys = append(keys, sortKey{Field: "nbDeParties"})
+		}
+	}
+	return keys
+}
+
+// ordre complet et stable du classement : userId départage les ex æquo
+func orderKeys() []sortKey {
+	return append(rankingKeys(), sortKey{Field: "userId"})
+}
+
+// document de tri MongoDB pour les clés données, éventuellement dans l'ordre inverse
+func rankingSort(keys []sortKey, reverse bool) bson.D {
+	sort := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		direction := 1
+		if key.Desc != reverse {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: key.Field, Value: direction})
+	}
+	return sort
+}
+
+// valeur du champ de tri pour un joueur
+func sortValue(player UserRanking, field string) interface{} {
+	switch field {
+	case "scoreTotal":
+		return player.Score
+	case "scoreAchievedAt":
+		return player.ScoreAchievedAt
+	case "nbDeParties":
+		return player.NbDeParties
+	default:
+		return player.UserID
+	}
+}
+
+// filtre des joueurs classés strictement avant (ahead) ou après le joueur selon les clés données
+func positionFilter(keys []sortKey, player UserRanking, ahead bool) bson.M {
+	or := make(bson.A, 0, len(keys))
+	for i, key := range keys {
+		clause := bson.M{}
+		for _, equal := range keys[:i] {
+			clause[equal.Field] = sortValue(player, equal.Field)
+		}
+		op := "$lt"
+		if key.Desc == ahead {
+			op = "$gt"
+		}
+		clause[key.Field] = bson.M{op: sortValue(player, key.Field)}
+		or = append(or, clause)
+	}
+	return bson.M{"$or": or}
+}
+
+func aheadOf(keys []sortKey, player UserRanking) bson.M { return positionFilter(keys, player, true) }
+func behind(keys []sortKey, player UserRanking) bson.M  { return positionFilter(keys, player, false) }
+
+// page du classement complet
+type leaderboardPage struct {
+	period
+	Country    string        `json:"country,omitempty"`
+	Players    []UserRanking `json:"players"`
+	Limit      int           `json:"limit"`
+	Offset     *int          `json:"offset,omitempty"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// position dans le classement, encodée dans le curseur de pagination
+type leaderboardCursor struct {
+	Score      int    `json:"s"`
+	AchievedAt int64  `json:"t,omitempty"`
+	Games      int    `json:"g,omitempty"`
+	UserID     string `json:"u"`
+}
+
+func encodeLeaderboardCursor(player UserRanking) string {
+	b, _ := json.Marshal(leaderboardCursor{
+		Score:      player.Score,
+		AchievedAt: player.ScoreAchievedAt.UnixMilli(),
+		Games:      player.NbDeParties,
+		UserID:     player.UserID,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// décode le curseur en un joueur fictif situé à la position encodée
+func decodeLeaderboardCursor(raw string) (UserRanking, error) {
+	var cursor leaderboardCursor
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return UserRanking{}, err
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return UserRanking{}, err
+	}
+	if cursor.UserID == "" {
+		return UserRanking{}, errors.New("curseur incomplet")
+	}
+	return UserRanking{
+		UserID:          cursor.UserID,
+		Score:           cursor.Score,
+		NbDeParties:     cursor.Games,
+		ScoreAchievedAt: time.UnixMilli(cursor.AchievedAt).UTC(),
+	}, nil
+}
+
+// convertit les joueurs du classement pour le paquet ranking
+func rankingEntries(players []UserRanking) []ranking.Entry {
+	entries := make([]ranking.Entry, len(players))
+	for i, player := range players {
+		entries[i] = ranking.Entry{
+			ID:          player.UserID,
+			Score:       player.Score,
+			AchievedAt:  player.ScoreAchievedAt,
+			GamesPlayed: player.NbDeParties,
+		}
+	}
+	return entries
+}
+
+// situe un joueur dans le classement complet : sa position dans l'ordre stable et son rang
+// selon la méthode configurée, calculés par des comptages sur l'index du classement (sur celui
+// de score_groups pour le rang dense)
+func rankStart(ctx context.Context, b board, player UserRanking) (ranking.Start, error) {
+	ahead, err := b.collection.CountDocuments(ctx, b.filter(aheadOf(orderKeys(), player)))
+	if err != nil {
+		return ranking.Start{}, err
+	}
+	start := ranking.Start{Position: int(ahead) + 1}
+
+	switch rankingConfig.Method {
+	case ranking.Ordinal:
+		start.Rank = start.Position
+	case ranking.Competition:
+		better, err := b.collection.CountDocuments(ctx, b.filter(aheadOf(rankingKeys(), player)))
+		if err != nil {
+			return ranking.Start{}, err
+		}
+		start.Rank = int(better) + 1
+	default:
+		//rang dense : nombre de groupes d'ex æquo classés devant le joueur, tenus dans score_groups
+		better, err := scoreGroupsCollection(b.collection.Database()).CountDocuments(ctx, b.groupFilter(aheadOf(rankingKeys(), player)))
+		if err != nil {
+			return ranking.Start{}, err
+		}
+		start.Rank = int(better) + 1
+	}
+	return start, nil
+}
+
+// rang d'un voisin immédiat (hors ex æquo) d'un joueur déjà classé : en rang dense c'est le rang
+// du joueur plus ou moins un, sans requête ; sinon un seul comptage sur l'index du classement
+func neighbourRank(ctx context.Context, b board, neighbour UserRanking, denseRank int) (int, error) {
+	keys := rankingKeys()
+	switch rankingConfig.Method {
+	case ranking.Ordinal:
+		keys = orderKeys()
+	case ranking.Competition:
+	default:
+		return denseRank, nil
+	}
+	ahead, err := b.collection.CountDocuments(ctx, b.filter(aheadOf(keys, neighbour)))
+	if err != nil {
+		return 0, err
+	}
+	return int(ahead) + 1, nil
+}
+
+// attribue les rangs d'une portion contiguë du classement, à partir de la position du premier joueur
+func assignRanks(ctx context.Context, b board, players []UserRanking) error {
+	if len(players) == 0 {
+		return nil
+	}
+	start, err := rankStart(ctx, b, players[0])
+	if err != nil {
+		return err
+	}
+	for i, rank := range rankingConfig.Assign(rankingEntries(players), start) {
+		players[i].Rank = rank
+	}
+	return nil
+}
+
+// lit les joueurs correspondant au filtre dans l'ordre demandé
+func findPlayers(ctx context.Context, b board, filter bson.M, opts *options.FindOptions) ([]UserRanking, error) {
+	cursor, err := b.collection.Find(ctx, b.filter(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	players := []UserRanking{}
+	if err = cursor.All(ctx, &players); err != nil {
+		return nil, err
+	}
+	return players, nil
+}
+
+// lit un paramètre entier de la requête, avec sa valeur par défaut et ses bornes
+func intQueryParam(r *http.Request, name string, def, min, max int) (int, *fieldError) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return 0, &fieldError{Field: name, Code: "range", Message: "Doit être un entier entre " + strconv.Itoa(min) + " et " + strconv.Itoa(max)}
+	}
+	return value, nil
+}
+
+// --------------- Handler gérant le classement ---------------------
+
+// handler pour parcourir le classement d'une période, par offset/limit ou par curseur
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	var errs []fieldError
+	limit, ferr := intQueryParam(r, "limit", defaultLeaderboardLimit, 1, maxLeaderboardLimit)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	offset, ferr := intQueryParam(r, "offset", 0, 0, math.MaxInt32)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	p, ferr := periodFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	country, ferr := countryFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+
+	filter := bson.M{}
+	rawCursor := r.URL.Query().Get("cursor")
+	if rawCursor != "" {
+		if r.URL.Query().Has("offset") {
+			errs = append(errs, fieldError{Field: "cursor", Code: "exclusive", Message: "cursor et offset ne peuvent pas être utilisés ensemble"})
+		} else if position, err := decodeLeaderboardCursor(rawCursor); err != nil {
+			errs = append(errs, fieldError{Field: "cursor", Code: "invalid", Message: "Curseur invalide"})
+		} else {
+			filter = behind(orderKeys(), position)
+		}
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres de pagination invalides", errs)
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	opts := options.Find().SetSort(rankingSort(orderKeys(), false)).SetLimit(int64(limit))
+	if rawCursor == "" {
+		opts.SetSkip(int64(offset))
+	}
+
+	var players []UserRanking
+	p, err = resolvePeriod(r.Context(), quizzerDB(client), p, time.Now())
+	b := periodBoard(quizzerDB(client), p).inCountry(country)
+	if err == nil {
+		players, err = findPlayers(r.Context(), b, filter, opts)
+	}
+	if err == nil {
+		err = assignRanks(r.Context(), b, players)
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du classement", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement", nil)
+		return
+	}
+
+	page := leaderboardPage{period: p, Country: country, Players: players, Limit: limit}
+	if rawCursor == "" {
+		page.Offset = &offset
+	}
+	if len(players) == limit {
+		page.NextCursor = encodeLeaderboardCursor(players[len(players)-1])
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handler qui renvoie radius joueurs au-dessus et en dessous de l'utilisateur connecté
+func aroundMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var errs []fieldError
+	radius, ferr := intQueryParam(r, "radius", defaultAroundMeRadius, 0, maxAroundMeRadius)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	p, ferr := periodFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	country, ferr := countryFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	var currentUser UserRanking
+	p, err = resolvePeriod(r.Context(), quizzerDB(client), p, time.Now())
+	b := periodBoard(quizzerDB(client), p).inCountry(country)
+	if err == nil {
+		err = b.collection.FindOne(r.Context(), b.filter(bson.M{"userId": userID})).Decode(&currentUser)
+	}
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, codeUserNotFound, "Utilisateur absent du classement", nil)
+		return
+	}
+
+	//une limite à 0 signifie « sans limite » pour MongoDB : radius=0 ne lit donc aucun voisin
+	var above, below []UserRanking
+	if err == nil && radius > 0 {
+		//les joueurs au-dessus sont lus dans l'ordre inverse puis remis dans l'ordre du classement
+		above, err = findPlayers(r.Context(), b,
+			aheadOf(orderKeys(), currentUser),
+			options.Find().SetSort(rankingSort(orderKeys(), true)).SetLimit(int64(radius)),
+		)
+		slices.Reverse(above)
+	}
+	if err == nil && radius > 0 {
+		below, err = findPlayers(r.Context(), b,
+			behind(orderKeys(), currentUser),
+			options.Find().SetSort(rankingSort(orderKeys(), false)).SetLimit(int64(radius)),
+		)
+	}
+	players := append(append(above, currentUser), below...)
+	if err == nil {
+		err = assignRanks(r.Context(), b, players)
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du classement", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement", nil)
+		return
+	}
+
+	response := struct {
+		period
+		Country string        `json:"country,omitempty"`
+		Players []UserRanking `json:"players"`
+		Radius  int           `json:"radius"`
+	}{
+		period:  p,
+		Country: country,
+		Players: players,
+		Radius:  radius,
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handler qui liste les saisons, de la plus récente à la plus ancienne
+func seasonsHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	db := quizzerDB(client)
+	seasons := []season{}
+	//crée la saison automatique en cours si besoin, pour qu'elle apparaisse dans la liste
+	_, err = currentSeason(r.Context(), db, time.Now())
+	if err == nil {
+		var cursor *mongo.Cursor
+		cursor, err = db.Collection("seasons").Find(r.Context(), bson.M{},
+			options.Find().SetSort(bson.D{{Key: "startsAt", Value: -1}}))
+		if err == nil {
+			err = cursor.All(r.Context(), &seasons)
+		}
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération des saisons", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération des saisons", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, seasons)
+}
+
+// handler qui renvoie le classement final archivé d'une saison terminée
+func seasonStandingsHandler(w http.ResponseWriter, r *http.Request) {
+	var errs []fieldError
+	seasonID := r.URL.Query().Get("season")
+	if seasonID == "" {
+		errs = append(errs, fieldError{Field: "season", Code: "required", Message: "L'identifiant de la saison est obligatoire"})
+	}
+	limit, ferr := intQueryParam(r, "limit", defaultLeaderboardLimit, 1, maxLeaderboardLimit)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	offset, ferr := intQueryParam(r, "offset", 0, 0, math.MaxInt32)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	db := quizzerDB(client)
+	var s season
+	err = db.Collection("seasons").FindOne(r.Context(), bson.M{"_id": seasonID}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, codeNotFound, "Saison introuvable", nil)
+		return
+	}
+	if err == nil && s.ArchivedAt == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "La saison n'est pas encore archivée", nil)
+		return
+	}
+
+	var players []UserRanking
+	if err == nil {
+		players, err = findPlayers(r.Context(),
+			board{collection: db.Collection("season_standings"), scope: bson.M{"seasonId": seasonID}},
+			bson.M{},
+			options.Find().SetSort(bson.D{{Key: "rank", Value: 1}, {Key: "userId", Value: 1}}).
+				SetSkip(int64(offset)).SetLimit(int64(limit)),
+		)
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du classement de la saison", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement de la saison", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, leaderboardPage{
+		period:  period{Kind: periodSeason, Key: seasonID},
+		Players: players,
+		Limit:   limit,
+		Offset:  &offset,
+	})
+}
@@ -3,76 +3,72 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
+	"os"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
 
+	setupLogger()
+	loadRankingConfig()
+	loadQuizModes()
+	loadQuestionTimeLimits()
+	loadQuestionCooldown()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	rand.Seed(time.Now().UnixNano())
-	http.HandleFunc("/signup", signUpHandler)
-	http.HandleFunc("/signin", signInHandler)
-	http.HandleFunc("/userinfo", userInfoHandler)
-	http.HandleFunc("/topPlayers", topPlayersHandler)
-	http.HandleFunc("/generate-question", generateQuizQuestionHandler)
-	http.HandleFunc("/finish-quizz", finishQuizHandler)
-	http.HandleFunc("/get-result", getQuizResultHandler)
 
-	createIndex()
+	rt := newRouter()
+	registerRoutes(rt)
+
+	if err := runMigrationsOnStart(); err != nil {
+		slog.Error("le serveur ne démarre pas sans ses migrations, à corriger puis relancer (ou appliquer avec la commande migrate)", "error", err)
+		os.Exit(1)
+	}
+	go runSeasonRollover(context.Background())
+	startChangeStream(context.Background())
 
 	//listes des ids
 	playlistTop50 := createTOP50Playlists()
 	saveTop50Playlists(playlistTop50)
 
-	//démarre le ticker pour exécuter les fonctions de récupération des données chaque heure
-	go func() {
-		ticker := time.NewTicker(24 * time.Hour) // actualise la bdd tous les 24h
-		defer ticker.Stop()
-
-		//execute apres selon le ticker
-		for {
-			select {
-			case <-ticker.C:
-				err := saveTop50Playlists(playlistTop50)
-				if err != nil {
-					log.Printf("Erreur lors de la sauvegarde des playlists Top 50: %v", err)
-				}
-				err = updateArtistsPopularityAndGenre()
-				if err != nil {
-					log.Printf("Erreur lors de l'update des artists: %v", err)
-				}
-			}
+	//actualise la bdd chaque jour à minuit UTC, juste avant la génération du défi du jour
+	refreshSpotifyData := func() {
+		err := saveTop50Playlists(playlistTop50)
+		if err != nil {
+			slog.Error("erreur lors de la sauvegarde des playlists Top 50", "error", err)
 		}
-	}()
+		err = updateArtistsPopularityAndGenre()
+		if err != nil {
+			slog.Error("erreur lors de la mise à jour des artistes", "error", err)
+		}
+	}
+	go runDailyChallengeScheduler(context.Background(), refreshSpotifyData)
 
-	log.Println("Le serveur est démarré sur le port 8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("Erreur lors du démarrage du serveur: %v", err)
+	slog.Info("le serveur est démarré", "port", 8080)
+	if err := http.ListenAndServe(":8080", withRequestID(withCORS(corsAllowedOrigins(), rt))); err != nil {
+		slog.Error("erreur lors du démarrage du serveur", "error", err)
+		os.Exit(1)
 	}
 }
 
-func createIndex() error {
-
-	// Connexion à MongoDB
+// applique les migrations en attente au démarrage ; le serveur ne doit pas démarrer sans elles,
+// car les index uniques qu'elles créent garantissent par exemple une seule tentative par joueur
+// au défi du jour
+func runMigrationsOnStart() error {
 	client, err := connectToMongo()
 	if err != nil {
 		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
 	}
 	defer client.Disconnect(context.TODO())
 
-	collection := client.Database("spotTrendQuizzer").Collection("classement")
-	indexModel := mongo.IndexModel{
-		Keys: bson.M{"scoreTotal": -1}, // Index pour tri décroissant
-	}
-	_, err = collection.Indexes().CreateOne(context.Background(), indexModel)
-	if err != nil {
-		log.Fatal("Failed to create index for collection classement:", err)
+	if err := migrateUp(context.Background(), client, 0); err != nil {
+		return fmt.Errorf("erreur lors de l'application des migrations: %w", err)
 	}
-	log.Println("Index  for collection classement created successfully")
 	return nil
 }
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// durée au-delà de laquelle un verrou de migration est considéré comme abandonné
+const migrationLockTimeout = 10 * time.Minute
+
+// intervalle entre deux tentatives de prise du verrou détenu par une autre instance
+const migrationLockPollInterval = 2 * time.Second
+
+// migration de schéma versionnée ; Up et Down doivent pouvoir être rejouées sans effet de bord
+type migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, client *mongo.Client) error
+	Down    func(ctx context.Context, client *mongo.Client) error
+}
+
+// migration enregistrée dans la collection schema_migrations
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// état d'une migration renvoyé par `migrate status`
+type migrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// migrations dans l'ordre d'application ; ne jamais modifier une version déjà déployée,
+// ajouter une nouvelle version à la fin
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "index scoreTotal de classement",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndex(ctx, quizzerDB(client).Collection("classement"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "scoreTotal", Value: -1}}, // Index pour tri décroissant
+				Options: options.Index().SetName("scoreTotal_-1"),
+			})
+		},
+		Down: dropIndex(quizzerDB, "classement", "scoreTotal_-1"),
+	},
+	{
+		Version: 2,
+		Name:    "renommage des champs de users en camelCase",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			migrated, err := migrateUserFields(ctx, quizzerDB(client))
+			if migrated > 0 {
+				slog.Info("champs de users migrés", "documents", migrated)
+			}
+			return err
+		},
+	},
+	{
+		Version: 3,
+		Name:    "index unique insensible à la casse sur classement.userId",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndex(ctx, quizzerDB(client).Collection("classement"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "userId", Value: 1}},
+				Options: options.Index().SetName("userId_ci_unique").SetUnique(true).SetCollation(pseudoCollation),
+			})
+		},
+		Down: dropIndex(quizzerDB, "classement", "userId_ci_unique"),
+	},
+	{
+		Version: 4,
+		Name:    "index unique insensible à la casse sur users.pseudo",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			conflicts, err := findPseudoConflicts(ctx, quizzerDB(client))
+			if err != nil {
+				return err
+			}
+			for _, conflict := range conflicts {
+				slog.Warn("pseudonymes en conflit (insensible à la casse)", "pseudos", conflict.Pseudos, "user_ids", conflict.UserIDs)
+			}
+			if len(conflicts) > 0 {
+				return fmt.Errorf("%d pseudonymes en conflit, voir la commande pseudo-conflicts", len(conflicts))
+			}
+			return createIndex(ctx, quizzerDB(client).Collection("users"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "pseudo", Value: 1}},
+				Options: options.Index().SetName("pseudo_ci_unique").SetUnique(true).SetCollation(pseudoCollation),
+			})
+		},
+		Down: dropIndex(quizzerDB, "users", "pseudo_ci_unique"),
+	},
+	{
+		Version: 5,
+		Name:    "index de users, artists et top50",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			if err := createIndex(ctx, quizzerDB(client).Collection("users"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "userId", Value: 1}},
+				Options: options.Index().SetName("userId_unique").SetUnique(true),
+			}); err != nil {
+				return err
+			}
+			artists := spotifyDB(client).Collection("artists")
+			if err := createIndex(ctx, artists, mongo.IndexModel{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetName("id_unique").SetUnique(true),
+			}); err != nil {
+				return err
+			}
+			if err := createIndex(ctx, artists, mongo.IndexModel{
+				Keys:    bson.D{{Key: "genre", Value: 1}},
+				Options: options.Index().SetName("genre_1"),
+			}); err != nil {
+				return err
+			}
+			return createIndex(ctx, spotifyDB(client).Collection("top50"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "country", Value: 1}},
+				Options: options.Index().SetName("country_1"),
+			})
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			for _, drop := range []func(context.Context, *mongo.Client) error{
+				dropIndex(quizzerDB, "users", "userId_unique"),
+				dropIndex(spotifyDB, "artists", "id_unique"),
+				dropIndex(spotifyDB, "artists", "genre_1"),
+				dropIndex(spotifyDB, "top50", "country_1"),
+			} {
+				if err := drop(ctx, client); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "index scoreTotal/userId de classement pour la pagination",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndex(ctx, quizzerDB(client).Collection("classement"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "scoreTotal", Value: -1}, {Key: "userId", Value: 1}},
+				Options: options.Index().SetName("scoreTotal_-1_userId_1"),
+			})
+		},
+		Down: dropIndex(quizzerDB, "classement", "scoreTotal_-1_userId_1"),
+	},
+	{
+		Version: 7,
+		Name:    "critères de départage du classement (scoreAchievedAt, nbDeParties)",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			migrated, err := backfillTieBreakers(ctx, quizzerDB(client))
+			if migrated > 0 {
+				slog.Info("critères de départage ajoutés au classement", "documents", migrated)
+			}
+			if err != nil {
+				return err
+			}
+			return createIndex(ctx, quizzerDB(client).Collection("classement"), mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "scoreTotal", Value: -1},
+					{Key: "scoreAchievedAt", Value: 1},
+					{Key: "nbDeParties", Value: 1},
+					{Key: "userId", Value: 1},
+				},
+				Options: options.Index().SetName("scoreTotal_-1_scoreAchievedAt_1_nbDeParties_1_userId_1"),
+			})
+		},
+		Down: dropIndex(quizzerDB, "classement", "scoreTotal_-1_scoreAchievedAt_1_nbDeParties_1_userId_1"),
+	},
+	{
+		Version: 8,
+		Name:    "index des classements par période et des saisons",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := quizzerDB(client)
+			for _, index := range []struct {
+				collection string
+				model      mongo.IndexModel
+			}{
+				{"period_scores", mongo.IndexModel{
+					Keys:    bson.D{{Key: "period", Value: 1}, {Key: "key", Value: 1}, {Key: "userId", Value: 1}},
+					Options: options.Index().SetName("period_1_key_1_userId_1_unique").SetUnique(true),
+				}},
+				{"period_scores", mongo.IndexModel{
+					Keys:    bson.D{{Key: "period", Value: 1}, {Key: "key", Value: 1}, {Key: "scoreTotal", Value: -1}, {Key: "userId", Value: 1}},
+					Options: options.Index().SetName("period_1_key_1_scoreTotal_-1_userId_1"),
+				}},
+				{"seasons", mongo.IndexModel{
+					Keys:    bson.D{{Key: "startsAt", Value: 1}, {Key: "endsAt", Value: 1}},
+					Options: options.Index().SetName("startsAt_1_endsAt_1"),
+				}},
+				{"season_standings", mongo.IndexModel{
+					Keys:    bson.D{{Key: "seasonId", Value: 1}, {Key: "userId", Value: 1}},
+					Options: options.Index().SetName("seasonId_1_userId_1_unique").SetUnique(true),
+				}},
+				{"season_standings", mongo.IndexModel{
+					Keys:    bson.D{{Key: "seasonId", Value: 1}, {Key: "rank", Value: 1}, {Key: "userId", Value: 1}},
+					Options: options.Index().SetName("seasonId_1_rank_1_userId_1"),
+				}},
+			} {
+				if err := createIndex(ctx, db.Collection(index.collection), index.model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			for _, drop := range []func(context.Context, *mongo.Client) error{
+				dropIndex(quizzerDB, "period_scores", "period_1_key_1_userId_1_unique"),
+				dropIndex(quizzerDB, "period_scores", "period_1_key_1_scoreTotal_-1_userId_1"),
+				dropIndex(quizzerDB, "seasons", "startsAt_1_endsAt_1"),
+				dropIndex(quizzerDB, "season_standings", "seasonId_1_userId_1_unique"),
+				dropIndex(quizzerDB, "season_standings", "seasonId_1_rank_1_userId_1"),
+			} {
+				if err := drop(ctx, client); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 9,
+		Name:    "index des classements par pays",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			db := quizzerDB(client)
+			if err := createIndex(ctx, db.Collection("classement"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "country", Value: 1}, {Key: "scoreTotal", Value: -1}, {Key: "userId", Value: 1}},
+				Options: options.Index().SetName("country_1_scoreTotal_-1_userId_1"),
+			}); err != nil {
+				return err
+			}
+			return createIndex(ctx, db.Collection("period_scores"), mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "period", Value: 1},
+					{Key: "key", Value: 1},
+					{Key: "country", Value: 1},
+					{Key: "scoreTotal", Value: -1},
+					{Key: "userId", Value: 1},
+				},
+				Options: options.Index().SetName("period_1_key_1_country_1_scoreTotal_-1_userId_1"),
+			})
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			if err := dropIndex(quizzerDB, "classement", "country_1_scoreTotal_-1_userId_1")(ctx, client); err != nil {
+				return err
+			}
+			return dropIndex(quizzerDB, "period_scores", "period_1_key_1_country_1_scoreTotal_-1_userId_1")(ctx, client)
+		},
+	},
+	{
+		Version: 10,
+		Name:    "index de l'historique des parties",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndex(ctx, quizzerDB(client).Collection("quiz_results"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "playedAt", Value: -1}, {Key: "_id", Value: -1}},
+				Options: options.Index().SetName("userId_1_playedAt_-1__id_-1"),
+			})
+		},
+		Down: dropIndex(quizzerDB, "quiz_results", "userId_1_playedAt_-1__id_-1"),
+	},
+	{
+		Version: 11,
+		Name:    "index du défi du jour",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			attempts := quizzerDB(client).Collection("daily_attempts")
+			for _, model := range []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "date", Value: 1}, {Key: "userId", Value: 1}},
+					Options: options.Index().SetName("date_1_userId_1_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "date", Value: 1}, {Key: "score", Value: -1}, {Key: "submittedAt", Value: 1}, {Key: "userId", Value: 1}},
+					Options: options.Index().SetName("date_1_score_-1_submittedAt_1_userId_1"),
+				},
+			} {
+				if err := createIndex(ctx, attempts, model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			if err := dropIndex(quizzerDB, "daily_attempts", "date_1_userId_1_unique")(ctx, client); err != nil {
+				return err
+			}
+			return dropIndex(quizzerDB, "daily_attempts", "date_1_score_-1_submittedAt_1_userId_1")(ctx, client)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "expiration des questions chronométrées",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndex(ctx, quizzerDB(client).Collection("issued_questions"), issuedQuestionsTTL)
+		},
+		Down: dropIndex(quizzerDB, "issued_questions", "issuedAt_1_ttl"),
+	},
+	{
+		Version: 13,
+		Name:    "questions récemment vues",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			seen := quizzerDB(client).Collection("seen_questions")
+			for _, model := range seenQuestionsIndexes {
+				if err := createIndex(ctx, seen, model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			if err := dropIndex(quizzerDB, "seen_questions", "userId_1_fingerprint_1_unique")(ctx, client); err != nil {
+				return err
+			}
+			return dropIndex(quizzerDB, "seen_questions", "expireAt_1_ttl")(ctx, client)
+		},
+	},
+	{
+		// l'index collationné de la version 3 ne sert aucune requête : les lectures de classement
+		// par userId n'utilisent pas la collation, et les userId générés par le serveur n'ont pas
+		// besoin d'être comparés sans tenir compte de la casse
+		Version: 14,
+		Name:    "index unique simple sur classement.userId",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			if err := createIndex(ctx, quizzerDB(client).Collection("classement"), classementUserIDIndex); err != nil {
+				return err
+			}
+			return dropIndex(quizzerDB, "classement", "userId_ci_unique")(ctx, client)
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			if err := createIndex(ctx, quizzerDB(client).Collection("classement"), mongo.IndexModel{
+				Keys:    bson.D{{Key: "userId", Value: 1}},
+				Options: options.Index().SetName("userId_ci_unique").SetUnique(true).SetCollation(pseudoCollation),
+			}); err != nil {
+				return err
+			}
+			return dropIndex(quizzerDB, "classement", "userId_unique")(ctx, client)
+		},
+	},
+	{
+		// les groupes eux-mêmes sont construits par syncScoreGroups à la fin de migrateUp
+		Version: scoreGroupsMigration,
+		Name:    "groupes d'ex æquo pour le rang dense",
+		Up: func(ctx context.Context, client *mongo.Client) error {
+			return createIndex(ctx, scoreGroupsCollection(quizzerDB(client)), mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "board", Value: 1},
+					{Key: "period", Value: 1},
+					{Key: "key", Value: 1},
+					{Key: "country", Value: 1},
+					{Key: "keys", Value: 1},
+					{Key: "scoreTotal", Value: -1},
+					{Key: "scoreAchievedAt", Value: 1},
+					{Key: "nbDeParties", Value: 1},
+				},
+				Options: options.Index().SetName("board_1_period_1_key_1_country_1_keys_1_scoreTotal_-1_scoreAchievedAt_1_nbDeParties_1_unique").SetUnique(true),
+			})
+		},
+		Down: func(ctx context.Context, client *mongo.Client) error {
+			if err := scoreGroupsCollection(quizzerDB(client)).Drop(ctx); err != nil {
+				return fmt.Errorf("erreur lors de la suppression des groupes de scores: %w", err)
+			}
+			if _, err := migrationsCollection(client).DeleteOne(ctx, bson.M{"_id": scoreGroupsStateID}); err != nil {
+				return fmt.Errorf("erreur lors de la suppression de l'état des groupes de scores: %w", err)
+			}
+			return nil
+		},
+	},
+}
+
+// index unique de classement.userId, utilisé par toutes les lectures du classement d'un joueur
+var classementUserIDIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "userId", Value: 1}},
+	Options: options.Index().SetName("userId_unique").SetUnique(true),
+}
+
+// noms des bases de données ; la commande bench-leaderboard les remplace par des bases jetables
+// pour y appliquer les migrations
+var (
+	quizzerDatabase = "spotTrendQuizzer"
+	spotifyDatabase = "spotifyData"
+)
+
+func quizzerDB(client *mongo.Client) *mongo.Database { return client.Database(quizzerDatabase) }
+func spotifyDB(client *mongo.Client) *mongo.Database { return client.Database(spotifyDatabase) }
+
+func migrationsCollection(client *mongo.Client) *mongo.Collection {
+	return quizzerDB(client).Collection("schema_migrations")
+}
+
+// crée l'index s'il n'existe pas déjà (CreateOne est sans effet pour un index identique)
+func createIndex(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel) error {
+	if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("erreur lors de la création d'un index sur %s: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+// supprime l'index nommé, sans erreur s'il n'existe plus
+func dropIndex(database func(*mongo.Client) *mongo.Database, collection, name string) func(ctx context.Context, client *mongo.Client) error {
+	return func(ctx context.Context, client *mongo.Client) error {
+		_, err := database(client).Collection(collection).Indexes().DropOne(ctx, name)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("erreur lors de la suppression de l'index %s sur %s: %w", name, collection, err)
+		}
+		return nil
+	}
+}
+
+// pose un verrou pour qu'une seule instance applique les migrations à la fois. Si une autre
+// instance le détient, attend qu'elle le libère (ou qu'il soit abandonné, au bout de
+// migrationLockTimeout) : l'appelant relit ensuite schema_migrations et n'applique que ce qui
+// reste à faire.
+func acquireMigrationLock(ctx context.Context, client *mongo.Client) (func(), error) {
+	collection := migrationsCollection(client)
+	deadline := time.Now().Add(migrationLockTimeout)
+	for waited := false; ; waited = true {
+		acquired, err := tryMigrationLock(ctx, collection, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		if !waited {
+			slog.Info("des migrations sont en cours sur une autre instance, attente de leur fin")
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("des migrations sont toujours en cours sur une autre instance")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+
+	return func() {
+		if _, err := collection.DeleteOne(context.Background(), bson.M{"_id": "lock"}); err != nil {
+			slog.Error("erreur lors de la libération du verrou de migration", "error", err)
+		}
+	}, nil
+}
+
+// tente une fois de poser le verrou de migration, ou de reprendre un verrou abandonné par une
+// instance arrêtée en cours de migration ; renvoie false si une autre instance le détient
+func tryMigrationLock(ctx context.Context, collection *mongo.Collection, now time.Time) (bool, error) {
+	_, err := collection.InsertOne(ctx, bson.M{"_id": "lock", "lockedAt": now})
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, fmt.Errorf("erreur lors de la pose du verrou de migration: %w", err)
+	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": "lock", "lockedAt": bson.M{"$lt": now.Add(-migrationLockTimeout)}},
+		bson.M{"$set": bson.M{"lockedAt": now}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("erreur lors de la reprise du verrou de migration: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// renvoie les migrations déjà appliquées, indexées par version
+func appliedMigrations(ctx context.Context, client *mongo.Client) (map[int]appliedMigration, error) {
+	cursor, err := migrationsCollection(client).Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des migrations appliquées: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var list []appliedMigration
+	if err = cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des migrations appliquées: %w", err)
+	}
+	applied := make(map[int]appliedMigration, len(list))
+	for _, m := range list {
+		applied[m.Version] = m
+	}
+	return applied, nil
+}
+
+// applique dans l'ordre les migrations non appliquées jusqu'à la version target (0 = toutes)
+func migrateUp(ctx context.Context, client *mongo.Client, target int) error {
+	unlock, err := acquireMigrationLock(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		slog.Info("application de la migration", "version", m.Version, "name", m.Name)
+		if err := m.Up(ctx, client); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		record := appliedMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}
+		if _, err := migrationsCollection(client).InsertOne(ctx, record); err != nil {
+			return fmt.Errorf("erreur lors de l'enregistrement de la migration %d: %w", m.Version, err)
+		}
+		applied[m.Version] = record
+	}
+
+	//les groupes d'ex æquo suivent les critères de rang configurés
+	if _, ok := applied[scoreGroupsMigration]; ok {
+		return syncScoreGroups(ctx, client, false)
+	}
+	return nil
+}
+
+// annule les steps dernières migrations appliquées, de la plus récente à la plus ancienne
+func migrateDown(ctx context.Context, client *mongo.Client, steps int) error {
+	unlock, err := acquireMigrationLock(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return fmt.Errorf("la migration %d (%s) est irréversible", m.Version, m.Name)
+		}
+
+		slog.Info("annulation de la migration", "version", m.Version, "name", m.Name)
+		if err := m.Down(ctx, client); err != nil {
+			return fmt.Errorf("annulation de la migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := migrationsCollection(client).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return fmt.Errorf("erreur lors de la suppression de la migration %d: %w", m.Version, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// renvoie l'état de chaque migration connue
+func migrationStatus(ctx context.Context, client *mongo.Client) ([]migrationState, error) {
+	applied, err := appliedMigrations(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]migrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := migrationState{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.AppliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
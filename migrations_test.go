@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// une instance qui trouve le verrou pris attend sa libération au lieu d'échouer, puis n'a plus
+// de migration à appliquer
+func TestMigrateUpWaitsForLock(t *testing.T) {
+	client := transactionalTestClient(t)
+	ctx := context.Background()
+	collection := migrationsCollection(client)
+
+	if _, err := collection.InsertOne(ctx, bson.M{"_id": "lock", "lockedAt": time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		collection.DeleteOne(context.Background(), bson.M{"_id": "lock"})
+		close(released)
+	}()
+
+	if err := migrateUp(ctx, client, 0); err != nil {
+		t.Fatalf("migrateUp a échoué pendant que le verrou était pris : %v", err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Fatal("migrateUp n'a pas attendu la libération du verrou")
+	}
+	applied, err := appliedMigrations(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("%d migrations appliquées, attendu %d", len(applied), len(migrations))
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// paramètre de chemin ou de requête d'une opération OpenAPI
+type openAPIParam struct {
+	Name        string
+	In          string
+	Required    bool
+	Type        string
+	Description string
+}
+
+// schémas des corps de requête et de réponse, référencés par nom dans apiRoutes
+var openAPISchemas = map[string]interface{}{
+	"Error": object(map[string]interface{}{
+		"code":    str(),
+		"message": str(),
+		"details": map[string]interface{}{"nullable": true},
+	}, "code", "message", "details"),
+	"Message": object(map[string]interface{}{"message": str()}, "message"),
+	"Token":   object(map[string]interface{}{"token": str()}, "token"),
+	"SignUpRequest": closed(object(map[string]interface{}{
+		"pseudo": map[string]interface{}{
+			"type": "string", "minLength": pseudoMinLength, "maxLength": pseudoMaxLength, "pattern": pseudoPattern.String(),
+		},
+		"password": map[string]interface{}{
+			"type": "string", "minLength": passwordMinLength, "maxLength": passwordMaxLength,
+			"description": "Au moins une lettre et un chiffre, sans le pseudonyme",
+		},
+		"country": country(),
+	}, "pseudo", "password")),
+	"ProfileUpdate": closed(object(map[string]interface{}{
+		"country": country(),
+	}, "country")),
+	"FieldError": object(map[string]interface{}{
+		"field":   str(),
+		"code":    str(),
+		"message": str(),
+	}, "field", "code", "message"),
+	"SignInRequest": object(map[string]interface{}{
+		"pseudo":   str(),
+		"password": str(),
+	}, "pseudo", "password"),
+	"UserRanking": object(map[string]interface{}{
+		"userId":          str(),
+		"pseudo":          str(),
+		"scoreTotal":      integer(),
+		"nbDeParties":     integer(),
+		"scoreAchievedAt": dateTime(),
+		"country":         str(),
+		"rank":            integer(),
+	}),
+	"UserRankingList": arrayOf(ref("UserRanking")),
+	"TopPlayers": map[string]interface{}{"oneOf": []interface{}{
+		ref("UserRankingList"),
+		map[string]interface{}{
+			"type":                 "object",
+			"description":          "Avec perCountry=true : meilleurs joueurs de chaque pays",
+			"additionalProperties": ref("UserRankingList"),
+		},
+	}},
+	"LeaderboardPage": object(map[string]interface{}{
+		"period":     str(),
+		"key":        str(),
+		"country":    str(),
+		"players":    arrayOf(ref("UserRanking")),
+		"limit":      integer(),
+		"offset":     integer(),
+		"nextCursor": str(),
+	}, "period", "players", "limit"),
+	"AroundMe": object(map[string]interface{}{
+		"period":  str(),
+		"key":     str(),
+		"country": str(),
+		"players": arrayOf(ref("UserRanking")),
+		"radius":  integer(),
+	}, "period", "players", "radius"),
+	"Season": object(map[string]interface{}{
+		"id":         str(),
+		"name":       str(),
+		"startsAt":   dateTime(),
+		"endsAt":     dateTime(),
+		"automatic":  map[string]interface{}{"type": "boolean"},
+		"archivedAt": dateTime(),
+	}, "id", "name", "startsAt", "endsAt", "automatic"),
+	"SeasonList": arrayOf(ref("Season")),
+	"User": object(map[string]interface{}{
+		"userID":       str(),
+		"pseudo":       str(),
+		"scoreTotal":   integer(),
+		"nbDeParties":  integer(),
+		"scoreHistory": arrayOf(str()),
+		"UserRanking":  arrayOf(ref("UserRanking")),
+		"country":      str(),
+	}),
+	"UserInfo": object(map[string]interface{}{
+		"userInfo": ref("User"),
+		"ranking":  arrayOf(ref("UserRanking")),
+	}),
+	"QuizResult": object(map[string]interface{}{
+		"UserID": str(),
+		"Score": map[string]interface{}{
+			"type":        "integer",
+			"description": "Ignoré : le score est calculé par le serveur. L'ancien chemin /finish-quizz accepte encore un quiz sans questionIds, enregistré avec 0 point",
+		},
+		"questionCount": integer(),
+		"answers":       arrayOf(ref("QuestionOutcome")),
+		"durationMs":    integer(),
+		"mode":          str(),
+		"questionIds": map[string]interface{}{
+			"type": "array", "items": str(),
+			"description": "Questions chronométrées du quiz (GET /questions/random) : le score, les réponses et le nombre de questions sont calculés par le serveur",
+		},
+	}, "questionIds"),
+	"QuestionOutcome": object(map[string]interface{}{
+		"type":    str(),
+		"genre":   str(),
+		"correct": map[string]interface{}{"type": "boolean"},
+	}, "correct"),
+	"Accuracy": object(map[string]interface{}{
+		"key":      str(),
+		"answers":  integer(),
+		"correct":  integer(),
+		"accuracy": number(),
+	}, "key", "answers", "correct", "accuracy"),
+	"PlayerStats": object(map[string]interface{}{
+		"days":              integer(),
+		"games":             integer(),
+		"answeredQuestions": integer(),
+		"averageScore":      number(),
+		"bestScore":         integer(),
+		"averageDurationMs": number(),
+		"accuracyByType":    arrayOf(ref("Accuracy")),
+		"currentStreakDays": integer(),
+		"longestStreakDays": integer(),
+		"gamesPerDay": arrayOf(object(map[string]interface{}{
+			"date":  str(),
+			"games": integer(),
+		}, "date", "games")),
+		"rankHistory": arrayOf(object(map[string]interface{}{
+			"playedAt": dateTime(),
+			"rank":     integer(),
+		}, "playedAt", "rank")),
+		"favouriteGenres": arrayOf(ref("Accuracy")),
+		"weakestGenres":   arrayOf(ref("Accuracy")),
+	}),
+	"QuizRecord": object(map[string]interface{}{
+		"id":            str(),
+		"userId":        str(),
+		"playedAt":      dateTime(),
+		"score":         integer(),
+		"questionCount": integer(),
+		"answers":       arrayOf(ref("QuestionOutcome")),
+		"durationMs":    integer(),
+		"mode":          str(),
+		"rankAfter":     integer(),
+	}, "id", "userId", "playedAt", "score", "questionCount", "answers", "durationMs", "mode"),
+	"HistoryPage": object(map[string]interface{}{
+		"results":    arrayOf(ref("QuizRecord")),
+		"limit":      integer(),
+		"nextCursor": str(),
+	}, "results", "limit"),
+	"QuizResultSummary": object(map[string]interface{}{
+		"scoreTotal":  integer(),
+		"userRanking": arrayOf(ref("UserRanking")),
+	}),
+	"QuestionTrend": object(map[string]interface{}{
+		"type":       str(),
+		"seed":       integer(),
+		"difficulty": integer(),
+		"question":   str(),
+		"choices":    arrayOf(str()),
+		"answer":     str(),
+	}),
+	"TimedQuestion": object(map[string]interface{}{
+		"id":          str(),
+		"type":        str(),
+		"difficulty":  integer(),
+		"question":    str(),
+		"choices":     arrayOf(str()),
+		"seed":        integer(),
+		"issuedAt":    dateTime(),
+		"deadline":    dateTime(),
+		"timeLimitMs": integer(),
+	}, "id", "type", "difficulty", "question", "choices", "issuedAt", "deadline", "timeLimitMs"),
+	"AnswerRequest": closed(object(map[string]interface{}{
+		"questionId": str(),
+		"answer":     str(),
+	}, "questionId", "answer")),
+	"AnswerResult": object(map[string]interface{}{
+		"questionId":  str(),
+		"correct":     map[string]interface{}{"type": "boolean"},
+		"answer":      str(),
+		"points":      integer(),
+		"elapsedMs":   integer(),
+		"timeLimitMs": integer(),
+	}, "questionId", "correct", "answer", "points", "elapsedMs", "timeLimitMs"),
+	"DailyQuestion": object(map[string]interface{}{
+		"type":     str(),
+		"question": str(),
+		"choices":  arrayOf(str()),
+		"answer":   str(),
+	}, "type", "question", "choices"),
+	"DailyAttempt": object(map[string]interface{}{
+		"date":        str(),
+		"userId":      str(),
+		"pseudo":      str(),
+		"country":     str(),
+		"answers":     arrayOf(str()),
+		"correct":     integer(),
+		"score":       integer(),
+		"submittedAt": dateTime(),
+		"rank":        integer(),
+	}, "date", "userId", "pseudo", "answers", "correct", "score", "submittedAt"),
+	"DailyChallenge": object(map[string]interface{}{
+		"date":      str(),
+		"questions": arrayOf(ref("DailyQuestion")),
+		"attempt":   map[string]interface{}{"allOf": []interface{}{ref("DailyAttempt")}, "nullable": true},
+	}, "date", "questions", "attempt"),
+	"DailyAnswer": closed(object(map[string]interface{}{
+		"date":    str(),
+		"answers": arrayOf(str()),
+	}, "answers")),
+	"DailyResult": object(map[string]interface{}{
+		"date":        str(),
+		"userId":      str(),
+		"pseudo":      str(),
+		"country":     str(),
+		"answers":     arrayOf(str()),
+		"correct":     integer(),
+		"score":       integer(),
+		"submittedAt": dateTime(),
+		"results": arrayOf(object(map[string]interface{}{
+			"answer":        str(),
+			"correctAnswer": str(),
+			"correct":       map[string]interface{}{"type": "boolean"},
+		}, "answer", "correctAnswer", "correct")),
+	}, "date", "userId", "answers", "correct", "score", "submittedAt", "results"),
+	"DailyLeaderboard": object(map[string]interface{}{
+		"date":    str(),
+		"players": arrayOf(ref("DailyAttempt")),
+	}, "date", "players"),
+	"EventStream": map[string]interface{}{
+		"type": "string",
+		"description": "Flux text/event-stream : événements « top » ({period, key, country, players}) " +
+			"et « rank » ({userId, player}), envoyés à la connexion puis à chaque changement",
+	},
+	"Object":    map[string]interface{}{"type": "object"},
+	"PlainText": str(),
+}
+
+func str() map[string]interface{}     { return map[string]interface{}{"type": "string"} }
+func integer() map[string]interface{} { return map[string]interface{}{"type": "integer"} }
+func number() map[string]interface{}  { return map[string]interface{}{"type": "number"} }
+func dateTime() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "format": "date-time"}
+}
+
+// pays choisi par le joueur, parmi les marchés suivis
+func country() map[string]interface{} {
+	values := []interface{}{""}
+	for _, c := range knownCountries() {
+		values = append(values, c)
+	}
+	return map[string]interface{}{"type": "string", "enum": values, "description": "Vide pour aucun pays"}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+// interdit les propriétés non décrites dans le schéma
+func closed(schema map[string]interface{}) map[string]interface{} {
+	schema["additionalProperties"] = false
+	return schema
+}
+
+func object(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// réponse d'erreur au format {code, message, details}
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": ref("Error")}},
+	}
+}
+
+// construit le document OpenAPI 3 à partir des routes réellement enregistrées
+func openAPISpec() map[string]interface{} {
+	paths := make(map[string]interface{})
+
+	for _, route := range apiRoutes() {
+		responses := map[string]interface{}{
+			"default": errorResponse("Erreur"),
+		}
+		mediaType := "application/json"
+		switch route.Response {
+		case "PlainText":
+			mediaType = "text/plain"
+		case "EventStream":
+			mediaType = "text/event-stream"
+		}
+		responses[strconv.Itoa(route.Status)] = map[string]interface{}{
+			"description": http.StatusText(route.Status),
+			"content":     map[string]interface{}{mediaType: map[string]interface{}{"schema": ref(route.Response)}},
+		}
+		if route.Auth {
+			responses["401"] = errorResponse("Token absent ou invalide")
+		}
+
+		operation := map[string]interface{}{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"responses":   responses,
+		}
+		if route.Auth {
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+		if route.Request != "" {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": ref(route.Request)}},
+			}
+		}
+		if len(route.Params) > 0 {
+			params := make([]map[string]interface{}, 0, len(route.Params))
+			for _, p := range route.Params {
+				params = append(params, map[string]interface{}{
+					"name":        p.Name,
+					"in":          p.In,
+					"required":    p.Required,
+					"description": p.Description,
+					"schema":      map[string]interface{}{"type": p.Type},
+				})
+			}
+			operation["parameters"] = params
+		}
+
+		item, ok := paths[route.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+
+		if route.LegacyPath != "" {
+			legacy := make(map[string]interface{}, len(operation)+1)
+			for k, v := range operation {
+				legacy[k] = v
+			}
+			legacy["operationId"] = route.OperationID + "Legacy"
+			legacy["deprecated"] = true
+			legacy["servers"] = []map[string]string{{"url": "/"}}
+			paths[route.LegacyPath] = map[string]interface{}{strings.ToLower(route.Method): legacy}
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "SpotTrend Quizzer API",
+			"version": "1.0.0",
+		},
+		"servers": []map[string]string{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": openAPISchemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// handler qui sert la spécification OpenAPI
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec())
+}
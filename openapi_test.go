@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vérifie que chaque opération publiée dans la spécification OpenAPI est servie par le routeur :
+// le chemin versionné ne doit répondre ni 404 ni 405, et chaque ancien chemin doit être signalé
+// comme déprécié. MongoDB est rendu injoignable pour que les handlers échouent vite après le
+// routage, sans pouvoir eux-mêmes répondre 404.
+func TestOpenAPIPathsAreRouted(t *testing.T) {
+	previous := mongoURI
+	mongoURI = "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=50&connectTimeoutMS=50"
+	defer func() { mongoURI = previous }()
+
+	rt := newRouter()
+	registerRoutes(rt)
+
+	paths, ok := openAPISpec()["paths"].(map[string]interface{})
+	if !ok || len(paths) == 0 {
+		t.Fatal("la spécification ne contient aucun chemin")
+	}
+	for path, rawItem := range paths {
+		item, ok := rawItem.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: entrée de chemin invalide", path)
+		}
+		for method, rawOperation := range item {
+			operation := rawOperation.(map[string]interface{})
+			legacy, _ := operation["deprecated"].(bool)
+			target := apiPrefix + path
+			if legacy {
+				target = path
+			}
+			method := strings.ToUpper(method)
+
+			t.Run(method+" "+target, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				req := httptest.NewRequest(method, target, strings.NewReader("{}")).WithContext(ctx)
+				req.Header.Set("Content-Type", "application/json")
+				rec := httptest.NewRecorder()
+				rt.ServeHTTP(rec, req)
+
+				if rec.Code == http.StatusNotFound || rec.Code == http.StatusMethodNotAllowed {
+					t.Fatalf("statut %d : l'opération n'est pas routée (%s)", rec.Code, rec.Body.String())
+				}
+				if legacy && rec.Header().Get("Deprecation") != "true" {
+					t.Fatalf("en-tête Deprecation absent sur l'ancien chemin")
+				}
+				if !legacy && rec.Header().Get("Deprecation") != "" {
+					t.Fatalf("en-tête Deprecation présent sur le chemin versionné")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"serveur/ranking"
+)
+
+// Classements par période. Chaque quiz terminé ajoute son score, dans period_scores, au
+// classement du jour, de la semaine ISO, du mois et de la saison en cours (en UTC) :
+//
+//	period_scores    : { period, key, userId, pseudo, scoreTotal, nbDeParties, scoreAchievedAt, country }
+//	seasons          : { _id, name, startsAt, endsAt, automatic, archivingAt, archivedAt }
+//	season_standings : { seasonId, userId, pseudo, scoreTotal, nbDeParties, scoreAchievedAt, country, rank }
+//
+// Les saisons ne se chevauchent pas. Une saison personnalisée est créée par la commande
+// `season create` ; en dehors de celles-ci, une saison automatique couvre le trimestre en
+// cours. Une fois terminée, une saison est archivée : son classement final, avec les rangs,
+// est recopié dans season_standings.
+
+// type de période d'un classement
+type periodKind string
+
+const (
+	periodAllTime periodKind = "all-time"
+	periodDaily   periodKind = "daily"
+	periodWeekly  periodKind = "weekly"
+	periodMonthly periodKind = "monthly"
+	periodSeason  periodKind = "season"
+)
+
+// périodes alimentées à chaque quiz, dans l'ordre
+var timedPeriods = []periodKind{periodDaily, periodWeekly, periodMonthly, periodSeason}
+
+// fréquence de la vérification des saisons terminées
+const seasonRolloverInterval = time.Hour
+
+// durée au-delà de laquelle la réservation d'une saison à archiver est considérée comme
+// abandonnée, et taille des lots de joueurs archivés
+const (
+	seasonArchiveTimeout   = 30 * time.Minute
+	seasonArchiveBatchSize = 1000
+)
+
+var (
+	dailyKeyPattern   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	weeklyKeyPattern  = regexp.MustCompile(`^\d{4}-W\d{2}$`)
+	monthlyKeyPattern = regexp.MustCompile(`^\d{4}-\d{2}$`)
+)
+
+// saison de la collection seasons
+type season struct {
+	ID         string     `bson:"_id" json:"id"`
+	Name       string     `bson:"name" json:"name"`
+	StartsAt   time.Time  `bson:"startsAt" json:"startsAt"`
+	EndsAt     time.Time  `bson:"endsAt" json:"endsAt"`
+	Automatic  bool       `bson:"automatic" json:"automatic"`
+	ArchivedAt *time.Time `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+}
+
+// classement d'une période donnée ; Key est vide pour le classement de tous les temps
+type period struct {
+	Kind periodKind `json:"period"`
+	Key  string     `json:"key,omitempty"`
+}
+
+// clé de la période de type kind contenant l'instant t (hors saisons)
+func periodKey(kind periodKind, t time.Time) string {
+	t = t.UTC()
+	switch kind {
+	case periodDaily:
+		return t.Format("2006-01-02")
+	case periodWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case periodMonthly:
+		return t.Format("2006-01")
+	default:
+		return ""
+	}
+}
+
+// vérifie le type de période et le format de la clé demandés par le client
+func validatePeriod(kind periodKind, key string) *fieldError {
+	var pattern *regexp.Regexp
+	switch kind {
+	case periodAllTime:
+		if key != "" {
+			return &fieldError{Field: "key", Code: "unexpected", Message: "Le classement de tous les temps n'a pas de clé"}
+		}
+		return nil
+	case periodDaily:
+		pattern = dailyKeyPattern
+	case periodWeekly:
+		pattern = weeklyKeyPattern
+	case periodMonthly:
+		pattern = monthlyKeyPattern
+	case periodSeason:
+		return nil
+	default:
+		return &fieldError{Field: "period", Code: "enum", Message: "Doit valoir all-time, daily, weekly, monthly ou season"}
+	}
+	if key != "" && !pattern.MatchString(key) {
+		return &fieldError{Field: "key", Code: "format", Message: "Format de clé invalide pour la période " + string(kind)}
+	}
+	return nil
+}
+
+// lit les paramètres period (all-time par défaut) et key (période en cours par défaut)
+func periodFromRequest(r *http.Request) (period, *fieldError) {
+	p := period{Kind: periodKind(r.URL.Query().Get("period")), Key: r.URL.Query().Get("key")}
+	if p.Kind == "" {
+		p.Kind = periodAllTime
+	}
+	if ferr := validatePeriod(p.Kind, p.Key); ferr != nil {
+		return period{}, ferr
+	}
+	return p, nil
+}
+
+// complète la clé de la période en cours si le client n'en a pas donné
+func resolvePeriod(ctx context.Context, db *mongo.Database, p period, now time.Time) (period, error) {
+	if p.Key != "" || p.Kind == periodAllTime {
+		return p, nil
+	}
+	if p.Kind == periodSeason {
+		current, err := currentSeason(ctx, db, now)
+		if err != nil {
+			return p, err
+		}
+		p.Key = current.ID
+		return p, nil
+	}
+	p.Key = periodKey(p.Kind, now)
+	return p, nil
+}
+
+// classement correspondant à la période
+func periodBoard(db *mongo.Database, p period) board {
+	if p.Kind == periodAllTime {
+		return allTimeBoard(db)
+	}
+	return board{
+		collection: db.Collection("period_scores"),
+		scope:      bson.M{"period": p.Kind, "key": p.Key},
+	}
+}
+
+// début du trimestre contenant t
+func quarterStart(t time.Time) time.Time {
+	t = t.UTC()
+	month := time.Month((int(t.Month())-1)/3*3 + 1)
+	return time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// renvoie la saison en cours ; si aucune saison ne couvre l'instant now, crée une saison
+// automatique qui couvre le reste du trimestre sans chevaucher les saisons existantes
+func currentSeason(ctx context.Context, db *mongo.Database, now time.Time) (season, error) {
+	seasons := db.Collection("seasons")
+	now = now.UTC()
+
+	var current season
+	err := seasons.FindOne(ctx,
+		bson.M{"startsAt": bson.M{"$lte": now}, "endsAt": bson.M{"$gt": now}},
+	).Decode(&current)
+	if err == nil {
+		return current, nil
+	} else if err != mongo.ErrNoDocuments {
+		return season{}, fmt.Errorf("erreur lors de la recherche de la saison en cours: %w", err)
+	}
+
+	start := quarterStart(now)
+	end := start.AddDate(0, 3, 0)
+	var previous, next season
+	err = seasons.FindOne(ctx, bson.M{"endsAt": bson.M{"$gt": start, "$lte": now}},
+		options.FindOne().SetSort(bson.D{{Key: "endsAt", Value: -1}}),
+	).Decode(&previous)
+	if err == nil {
+		start = previous.EndsAt
+	} else if err != mongo.ErrNoDocuments {
+		return season{}, fmt.Errorf("erreur lors de la recherche de la saison précédente: %w", err)
+	}
+	err = seasons.FindOne(ctx, bson.M{"startsAt": bson.M{"$gt": now, "$lt": end}},
+		options.FindOne().SetSort(bson.D{{Key: "startsAt", Value: 1}}),
+	).Decode(&next)
+	if err == nil {
+		end = next.StartsAt
+	} else if err != mongo.ErrNoDocuments {
+		return season{}, fmt.Errorf("erreur lors de la recherche de la saison suivante: %w", err)
+	}
+
+	//l'identifiant dépend seulement du début : plusieurs instances créent la même saison
+	current = season{
+		ID:        "auto-" + start.Format("2006-01-02"),
+		Name:      "Saison du " + start.Format("2006-01-02"),
+		StartsAt:  start,
+		EndsAt:    end,
+		Automatic: true,
+	}
+	_, err = seasons.UpdateOne(ctx, bson.M{"_id": current.ID},
+		bson.M{"$setOnInsert": current},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return season{}, fmt.Errorf("erreur lors de la création de la saison automatique: %w", err)
+	}
+	err = seasons.FindOne(ctx, bson.M{"_id": current.ID}).Decode(&current)
+	if err != nil {
+		return season{}, fmt.Errorf("erreur lors de la lecture de la saison automatique: %w", err)
+	}
+	return current, nil
+}
+
+// crée une saison personnalisée ; les saisons automatiques qu'elle chevauche sont écourtées,
+// ou supprimées si elles n'ont pas encore commencé
+func createSeason(ctx context.Context, db *mongo.Database, s season, now time.Time) error {
+	if s.ID == "" || s.Name == "" {
+		return errors.New("l'identifiant et le nom de la saison sont obligatoires")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("la saison doit se terminer après son début")
+	}
+	if s.StartsAt.Before(now) {
+		return errors.New("une saison ne peut pas commencer dans le passé")
+	}
+	s.StartsAt, s.EndsAt, s.Automatic = s.StartsAt.UTC(), s.EndsAt.UTC(), false
+
+	seasons := db.Collection("seasons")
+	overlapping := bson.M{"startsAt": bson.M{"$lt": s.EndsAt}, "endsAt": bson.M{"$gt": s.StartsAt}}
+
+	var conflict season
+	err := seasons.FindOne(ctx, bson.M{"$and": bson.A{overlapping, bson.M{"automatic": false}}}).Decode(&conflict)
+	if err == nil {
+		return fmt.Errorf("la saison chevauche la saison %s", conflict.ID)
+	} else if err != mongo.ErrNoDocuments {
+		return fmt.Errorf("erreur lors de la vérification des saisons: %w", err)
+	}
+
+	return newTransactionRunner(db.Client()).runInTransaction(ctx, func(ctx context.Context) error {
+		automatic := bson.M{"$and": bson.A{overlapping, bson.M{"automatic": true}}}
+		if _, err := seasons.DeleteMany(ctx, bson.M{"$and": bson.A{automatic, bson.M{"startsAt": bson.M{"$gte": s.StartsAt}}}}); err != nil {
+			return fmt.Errorf("erreur lors de la suppression des saisons automatiques: %w", err)
+		}
+		if _, err := seasons.UpdateMany(ctx, automatic, bson.M{"$set": bson.M{"endsAt": s.StartsAt}}); err != nil {
+			return fmt.Errorf("erreur lors du raccourcissement des saisons automatiques: %w", err)
+		}
+		if _, err := seasons.InsertOne(ctx, s); err != nil {
+			return fmt.Errorf("erreur lors de la création de la saison: %w", err)
+		}
+		return nil
+	})
+}
+
+// ajoute le score d'un quiz aux classements de chaque période en cours
+func recordPeriodScores(ctx context.Context, db *mongo.Database, user User, score int, now time.Time) error {
+	now = now.UTC()
+	for _, kind := range timedPeriods {
+		p, err := resolvePeriod(ctx, db, period{Kind: kind}, now)
+		if err != nil {
+			return err
+		}
+		//scoreAchievedAt ne change que si le score de la période change, comme dans classement
+		set := bson.M{"pseudo": user.Pseudo}
+		update := bson.M{
+			"$inc": bson.M{"scoreTotal": score, "nbDeParties": 1},
+			"$set": set,
+		}
+		if score != 0 {
+			set["scoreAchievedAt"] = now
+		} else {
+			update["$setOnInsert"] = bson.M{"scoreAchievedAt": now}
+		}
+		if user.Country != "" {
+			set["country"] = user.Country
+		}
+		var previous UserRanking
+		err = db.Collection("period_scores").FindOneAndUpdate(ctx,
+			bson.M{"period": p.Kind, "key": p.Key, "userId": user.UserID},
+			update,
+			options.FindOneAndUpdate().SetUpsert(true),
+		).Decode(&previous)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return fmt.Errorf("erreur lors de la mise à jour du classement %s %s: %w", p.Kind, p.Key, err)
+		}
+
+		//état du joueur avant et après la partie, pour ses groupes d'ex æquo ; sans document
+		//précédent, la partie vient de l'ajouter au classement de la période
+		var before *UserRanking
+		after := UserRanking{Score: score, NbDeParties: 1, ScoreAchievedAt: now, Country: user.Country}
+		if err == nil {
+			before = &previous
+			after = previous
+			after.Score += score
+			after.NbDeParties++
+			if score != 0 {
+				after.ScoreAchievedAt = now
+			}
+			if user.Country != "" {
+				after.Country = user.Country
+			}
+		}
+		if err := updateScoreGroups(ctx, periodBoard(db, p), before, &after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archive le classement final des saisons terminées et pas encore archivées, puis s'assure
+// qu'une saison couvre l'instant présent
+func rollOverSeasons(ctx context.Context, db *mongo.Database, now time.Time) error {
+	cursor, err := db.Collection("seasons").Find(ctx,
+		bson.M{"endsAt": bson.M{"$lte": now}, "archivedAt": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "endsAt", Value: 1}}),
+	)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la recherche des saisons terminées: %w", err)
+	}
+	var ended []season
+	if err = cursor.All(ctx, &ended); err != nil {
+		return fmt.Errorf("erreur lors de la lecture des saisons terminées: %w", err)
+	}
+
+	for _, s := range ended {
+		count, archived, err := archiveSeason(ctx, db, s.ID, now)
+		if err != nil {
+			return err
+		}
+		if archived {
+			slog.Info("saison archivée", "season", s.ID, "players", count)
+		} else {
+			slog.Info("saison en cours d'archivage sur une autre instance", "season", s.ID)
+		}
+	}
+
+	_, err = currentSeason(ctx, db, now)
+	return err
+}
+
+// recopie le classement final de la saison, avec les rangs, dans season_standings. La saison
+// est d'abord réservée (archivingAt) pour qu'une seule instance l'archive ; archived est faux si
+// une autre instance l'archive déjà. Les joueurs sont lus dans l'ordre du classement et écrits
+// par lots, sans charger la saison en mémoire ; chaque entrée est remplacée par
+// (seasonId, userId), si bien qu'une archive interrompue peut être recommencée, une fois sa
+// réservation expirée, sans doublon.
+func archiveSeason(ctx context.Context, db *mongo.Database, seasonID string, now time.Time) (count int, archived bool, err error) {
+	seasons := db.Collection("seasons")
+	now = now.UTC()
+	claim, err := seasons.UpdateOne(ctx,
+		bson.M{
+			"_id":        seasonID,
+			"archivedAt": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"archivingAt": bson.M{"$exists": false}},
+				bson.M{"archivingAt": bson.M{"$lt": now.Add(-seasonArchiveTimeout)}},
+			},
+		},
+		bson.M{"$set": bson.M{"archivingAt": now}},
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("erreur lors de la réservation de la saison %s: %w", seasonID, err)
+	}
+	if claim.ModifiedCount == 0 {
+		return 0, false, nil
+	}
+
+	b := periodBoard(db, period{Kind: periodSeason, Key: seasonID})
+	cursor, err := b.collection.Find(ctx, b.filter(bson.M{}),
+		options.Find().SetSort(rankingSort(orderKeys(), false)).SetBatchSize(seasonArchiveBatchSize).SetAllowDiskUse(true),
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("erreur lors de la lecture du classement de la saison %s: %w", seasonID, err)
+	}
+	defer cursor.Close(ctx)
+
+	standings := db.Collection("season_standings")
+	var last *UserRanking
+	page := make([]UserRanking, 0, seasonArchiveBatchSize)
+	flush := func() error {
+		if len(page) == 0 {
+			return nil
+		}
+		//les rangs d'un lot reprennent à partir du dernier joueur du lot précédent
+		start, players := ranking.Top, page
+		if last != nil {
+			start = ranking.Start{Rank: last.Rank, Position: count}
+			players = append([]UserRanking{*last}, page...)
+		}
+		ranks := rankingConfig.Assign(rankingEntries(players), start)
+		players, ranks = players[len(players)-len(page):], ranks[len(ranks)-len(page):]
+
+		writes := make([]mongo.WriteModel, len(players))
+		for i := range players {
+			players[i].Rank = ranks[i]
+			writes[i] = mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"seasonId": seasonID, "userId": players[i].UserID}).
+				SetReplacement(struct {
+					SeasonID    string `bson:"seasonId"`
+					UserRanking `bson:",inline"`
+				}{seasonID, players[i]}).
+				SetUpsert(true)
+		}
+		if _, err := standings.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("erreur lors de l'archivage de la saison %s: %w", seasonID, err)
+		}
+		count += len(players)
+		final := players[len(players)-1]
+		last = &final
+		page = page[:0]
+		return nil
+	}
+	for cursor.Next(ctx) {
+		var player UserRanking
+		if err := cursor.Decode(&player); err != nil {
+			return 0, false, fmt.Errorf("erreur lors de la lecture du classement de la saison %s: %w", seasonID, err)
+		}
+		page = append(page, player)
+		if len(page) == seasonArchiveBatchSize {
+			if err := flush(); err != nil {
+				return 0, false, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, false, fmt.Errorf("erreur lors de la lecture du classement de la saison %s: %w", seasonID, err)
+	}
+	if err := flush(); err != nil {
+		return 0, false, err
+	}
+
+	_, err = seasons.UpdateOne(ctx, bson.M{"_id": seasonID}, bson.M{
+		"$set":   bson.M{"archivedAt": now},
+		"$unset": bson.M{"archivingAt": ""},
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("erreur lors de l'archivage de la saison %s: %w", seasonID, err)
+	}
+	return count, true, nil
+}
+
+// vérifie régulièrement la fin des saisons, jusqu'à l'annulation du contexte
+func runSeasonRollover(ctx context.Context) {
+	rollOver := func() {
+		client, err := connectToMongo()
+		if err != nil {
+			slog.Error("erreur lors de la connexion à MongoDB", "error", err)
+			return
+		}
+		defer client.Disconnect(context.Background())
+
+		if err := rollOverSeasons(ctx, quizzerDB(client), time.Now()); err != nil {
+			slog.Error("erreur lors du changement de saison", "error", err)
+		}
+	}
+
+	rollOver()
+	ticker := time.NewTicker(seasonRolloverInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rollOver()
+		}
+	}
+}
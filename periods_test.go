@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"serveur/ranking"
+)
+
+// l'archive d'une saison donne à chaque joueur le rang du classement complet, y compris d'un
+// lot à l'autre, et n'est faite qu'une fois
+func TestArchiveSeason(t *testing.T) {
+	configured := rankingConfig
+	defer func() { rankingConfig = configured }()
+	rankingConfig = ranking.Config{Method: ranking.Competition}
+
+	client := transactionalTestClient(t)
+	ctx := context.Background()
+	db := quizzerDB(client)
+	now := time.Now().UTC()
+
+	const seasonID = "test"
+	if _, err := db.Collection("seasons").InsertOne(ctx, season{ID: seasonID, Name: "Test", StartsAt: now.AddDate(0, -1, 0), EndsAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(5))
+	var players []UserRanking
+	docs := make([]interface{}, 0, 2*seasonArchiveBatchSize+10)
+	for i := 0; i < cap(docs); i++ {
+		player := UserRanking{UserID: fmt.Sprintf("u%05d", i), Pseudo: fmt.Sprintf("joueur%d", i), Score: rng.Intn(50) * 10}
+		players = append(players, player)
+		docs = append(docs, bson.M{"period": periodSeason, "key": seasonID, "userId": player.UserID, "pseudo": player.Pseudo, "scoreTotal": player.Score})
+	}
+	if _, err := db.Collection("period_scores").InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	count, archived, err := archiveSeason(ctx, db, seasonID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archived || count != len(players) {
+		t.Fatalf("archivage : %d joueurs, archivée %v ; attendu %d joueurs", count, archived, len(players))
+	}
+	if _, archived, err := archiveSeason(ctx, db, seasonID, now); err != nil || archived {
+		t.Fatalf("deuxième archivage : archivée %v (%v), attendu aucun", archived, err)
+	}
+
+	entries := rankingEntries(players)
+	want := make(map[string]int)
+	for i, rank := range rankingConfig.Rank(entries) {
+		want[entries[i].ID] = rank
+	}
+	cursor, err := db.Collection("season_standings").Find(ctx, bson.M{"seasonId": seasonID}, options.Find().SetSort(bson.D{{Key: "rank", Value: 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var standings []UserRanking
+	if err := cursor.All(ctx, &standings); err != nil {
+		t.Fatal(err)
+	}
+	if len(standings) != len(players) {
+		t.Fatalf("%d entrées archivées, attendu %d", len(standings), len(players))
+	}
+	for _, standing := range standings {
+		if standing.Rank != want[standing.UserID] {
+			t.Fatalf("rang archivé de %s = %d, attendu %d", standing.UserID, standing.Rank, want[standing.UserID])
+		}
+	}
+}
+
+// une saison réservée par une autre instance n'est pas archivée, sauf si la réservation a expiré
+func TestArchiveSeasonSkipsClaimedSeason(t *testing.T) {
+	client := transactionalTestClient(t)
+	ctx := context.Background()
+	db := quizzerDB(client)
+	now := time.Now().UTC()
+
+	_, err := db.Collection("seasons").InsertOne(ctx, bson.M{
+		"_id": "test", "name": "Test", "startsAt": now.AddDate(0, -1, 0), "endsAt": now, "archivingAt": now.Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, archived, err := archiveSeason(ctx, db, "test", now); err != nil || archived {
+		t.Fatalf("saison réservée : archivée %v (%v), attendu aucun archivage", archived, err)
+	}
+	if _, archived, err := archiveSeason(ctx, db, "test", now.Add(seasonArchiveTimeout)); err != nil || !archived {
+		t.Fatalf("réservation expirée : archivée %v (%v), attendu un archivage", archived, err)
+	}
+}
@@ -2,414 +2,771 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type QuestionTrend struct {
-	Question string   `json:"question"`
-	Choices  []string `json:"choices"`
-	Answer   string   `json:"answer"`
+	Type       string   `json:"type"`       // identifiant du générateur de la question
+	Seed       int64    `json:"seed"`       // graine qui redonne la même question sur les mêmes données
+	Difficulty int      `json:"difficulty"` // difficulté, de difficultyEasy à difficultyHard
+	Question   string   `json:"question"`
+	Choices    []string `json:"choices"`
+	Answer     string   `json:"answer"`
+	Genre      string   `json:"-"` // famille de genres de la question, pour les statistiques du joueur
+	Entities   []string `json:"-"` // artistes et pistes de la question, pour ne pas la reposer trop tôt
 }
 
+// résultat d'un quiz envoyé par le client ; QuestionIDs (questions chronométrées) est
+// obligatoire, le score et les réponses étant calculés par le serveur, les autres champs
+// alimentent l'historique des parties (quiz_results). Score n'est jamais crédité : seul l'ancien
+// chemin /finish-quizz accepte encore un quiz sans QuestionIDs, enregistré avec 0 point
 type QuizResult struct {
-	UserID string
-	Score  int
+	UserID        string
+	Score         int
+	QuestionCount int               `json:"questionCount"`
+	Answers       []questionOutcome `json:"answers"`
+	DurationMs    int64             `json:"durationMs"`
+	Mode          string            `json:"mode"`
+	QuestionIDs   []string          `json:"questionIds"`
 }
 
 const (
-	TopArtistsQuestionType = iota
-	GenreQuestionType
-	RegionalTrendsQuestionType
+	// nombre de choix proposés pour une question sur la popularité des artistes
+	topArtistsChoices = 4
+	// écart minimal de popularité entre la réponse et les autres choix, pour que la question soit juste
+	minPopularityGap = 5
+	// nombre d'artistes tirés au sort parmi lesquels on cherche 4 choix assez espacés
+	topArtistsSampleSize = 12
 )
 
-// génère une question sur la popularité des artistes
-func generateTopArtistsQuestion(db *mongo.Database) (QuestionTrend, error) {
-	artistsCollection := db.Collection("artists")
-	//sélectionne 4 artistes aléatoires dans la collection artists
-	pipeline := mongo.Pipeline{
-		{{Key: "$sample", Value: bson.D{{Key: "size", Value: 4}}}},
-	}
-	cursor, err := artistsCollection.Aggregate(context.Background(), pipeline)
-	if err != nil {
-		log.Printf("Erreur lors de l'agrégation des artistes: %v", err)
-		return QuestionTrend{}, fmt.Errorf("erreur lors de l'agrégation des artistes: %w", err)
-	}
-	defer cursor.Close(context.TODO())
-
-	var artists []Artist
-	if err = cursor.All(context.TODO(), &artists); err != nil {
-		log.Printf("Erreur lors de la récupération des artistes: %v", err)
-		return QuestionTrend{}, fmt.Errorf("erreur lors de la récupération des artistes: %w", err)
+// construit une question sur la popularité des artistes à partir des artistes tirés au sort :
+// la réponse devance chacun des 3 autres choix d'au moins minPopularityGap, les artistes sans
+// popularité sont ignorés et deux choix n'ont jamais la même popularité
+func buildTopArtistsQuestion(candidates []Artist, rng *rand.Rand) (QuestionTrend, error) {
+	//écarte les artistes sans popularité, les doublons et les popularités déjà représentées
+	seenNames := make(map[string]bool)
+	seenPopularity := make(map[int]bool)
+	var pool []Artist
+	for _, artist := range candidates {
+		if artist.Name == "" || artist.Popularity <= 0 || seenNames[artist.Name] || seenPopularity[artist.Popularity] {
+			continue
+		}
+		seenNames[artist.Name] = true
+		seenPopularity[artist.Popularity] = true
+		pool = append(pool, artist)
 	}
-	if len(artists) == 0 {
-		log.Println("Aucun artiste trouvé")
-		return QuestionTrend{}, fmt.Errorf("aucun artiste trouvé")
+	if len(pool) < topArtistsChoices {
+		return QuestionTrend{}, fmt.Errorf("pas assez d'artistes avec une popularité distincte (%d sur %d)", len(pool), topArtistsChoices)
 	}
+	sort.Slice(pool, func(i, j int) bool { return pool[i].Popularity > pool[j].Popularity })
 
-	//création de la question
-	question := QuestionTrend{
-		Choices:  make([]string, 0, 4),
-		Question: "Quel est l'artiste le plus streamé?",
-	}
+	//cherche, du plus populaire au moins populaire, un artiste qui devance assez 3 autres artistes
+	for i, answer := range pool {
+		var others []Artist
+		for _, artist := range pool[i+1:] {
+			if answer.Popularity-artist.Popularity >= minPopularityGap {
+				others = append(others, artist)
+			}
+		}
+		if len(others) < topArtistsChoices-1 {
+			continue
+		}
+		rng.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
 
-	//détermine quel artiste est le plus populaire parmi les 4 et le met comme réponse
-	var mostPopular Artist
-	for _, artist := range artists {
-		question.Choices = append(question.Choices, artist.Name)
-		if mostPopular.Name == "" || artist.Popularity > mostPopular.Popularity {
-			mostPopular = artist
+		question := QuestionTrend{
+			Question: "Quel est l'artiste le plus streamé?",
+			Choices:  []string{answer.Name},
+			Answer:   answer.Name,
+			Entities: []string{artistEntity(answer.ID)},
 		}
+		runnerUp := 0
+		for _, artist := range others[:topArtistsChoices-1] {
+			question.Choices = append(question.Choices, artist.Name)
+			question.Entities = append(question.Entities, artistEntity(artist.ID))
+			if artist.Popularity > runnerUp {
+				runnerUp = artist.Popularity
+			}
+		}
+		question.Difficulty = popularityGapDifficulty(answer.Popularity-runnerUp, answer.Popularity)
+		rng.Shuffle(len(question.Choices), func(i, j int) { question.Choices[i], question.Choices[j] = question.Choices[j], question.Choices[i] })
+		return question, nil
 	}
-
-	return question, nil
+	return QuestionTrend{}, fmt.Errorf("aucun artiste ne devance 3 autres artistes d'au moins %d points de popularité", minPopularityGap)
 }
 
-// génère une question sur le genre le plus représenté parmi les artistes
-func generateGenreQuestion(db *mongo.Database) (QuestionTrend, error) {
-
-	genres := []string{"j-pop", "rock", "jazz", "blues", "classical", "rap",
-		"r&b", "pop", "hip hop", "french hip hop", "k-pop"}
-
-	//choisis aléatoirement un genre principal et un genre secondaire
-	rand.Shuffle(len(genres), func(i, j int) { genres[i], genres[j] = genres[j], genres[i] })
-	mainGenre := genres[0]
-	secondaryGenre := genres[1] //en s'assurant qu'ils soient différent
-
-	// sélectionne 3 artistes correspondant au genre principal et 1 artiste avec un autre genere
-	artistsCollection := db.Collection("artists")
-	pipeline := mongo.Pipeline{
-		bson.D{{Key: "$match", Value: bson.D{{Key: "genre", Value: mainGenre}}}},
-		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: 3}}}},
-		bson.D{{Key: "$unionWith", Value: bson.D{
-			{Key: "coll", Value: "artists"},
-			{Key: "pipeline", Value: mongo.Pipeline{
-				bson.D{{Key: "$match", Value: bson.D{{Key: "genre", Value: secondaryGenre}}}},
-				bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: 1}}}},
-			}},
-		}}},
-	}
+// générateur des questions sur la popularité des artistes
+type topArtistsGenerator struct{}
 
-	cursor, err := artistsCollection.Aggregate(context.Background(), pipeline)
-	if err != nil {
-		return QuestionTrend{}, fmt.Errorf("erreur lors de l'agrégation des artistes: %w", err)
-	}
-	defer cursor.Close(context.TODO())
+func init() { registerGenerator(topArtistsGenerator{}) }
 
-	var artists []Artist
-	if err = cursor.All(context.TODO(), &artists); err != nil {
-		return QuestionTrend{}, fmt.Errorf("erreur lors de la récupération des artistes: %w", err)
-	}
-	if len(artists) == 0 {
-		return QuestionTrend{}, fmt.Errorf("aucun artiste trouvé pour générer une question de genre")
-	}
+func (topArtistsGenerator) ID() string      { return "top-artists" }
+func (topArtistsGenerator) Name() string    { return "Artiste le plus streamé" }
+func (topArtistsGenerator) Difficulty() int { return difficultyEasy }
 
-	//création de la question
-	question := QuestionTrend{
-		Question: fmt.Sprintf("Quel est le genre musical le plus représenté parmi ces artistes : %s,  %s,  %s,  %s",
-			artists[0].Name,
-			artists[1].Name,
-			artists[2].Name,
-			artists[3].Name),
-		Answer:  mainGenre,
-		Choices: make([]string, 0, 4),
+// génère une question sur la popularité des artistes
+func (topArtistsGenerator) Generate(ctx context.Context, db *mongo.Database, rng *rand.Rand, opts generationOptions) (QuestionTrend, error) {
+	//sélectionne des artistes aléatoires ayant une popularité dans la collection artists
+	artists, err := sampleArtists(ctx, db, bson.M{"popularity": bson.M{"$gt": 0}}, topArtistsSampleSize, rng, opts.Recent)
+	if err != nil {
+		return QuestionTrend{}, err
 	}
-	//création des choix
-	question.Choices = append(question.Choices, mainGenre)
-	question.Choices = append(question.Choices, secondaryGenre)
-	rand.Shuffle(len(genres), func(i, j int) { genres[i], genres[j] = genres[j], genres[i] })
+	return buildTopArtistsQuestion(artists, rng)
+}
 
-	for _, genre := range genres {
-		if genre != mainGenre && genre != secondaryGenre && len(question.Choices) < 4 {
-			question.Choices = append(question.Choices, genre)
-		}
-	}
-	// Mélanger les choix
-	rand.Shuffle(len(question.Choices), func(i, j int) { question.Choices[i], question.Choices[j] = question.Choices[j], question.Choices[i] })
+// nombre maximal de familles essayées avant d'abandonner la question de genre
+const maxGenreFamilyAttempts = 5
 
-	return question, nil
-}
+// générateur des questions sur le genre le plus représenté parmi des artistes
+type genreGenerator struct{}
 
-// génère une question sur la popularité d'une piste dans un pays
-func generateRegionalTrendsQuestion(db *mongo.Database) (QuestionTrend, error) {
-	top50Collection := db.Collection("top50")
-	//sélectionne 4 playlists aléatoires de la collection 'top50'
-	pipeline := mongo.Pipeline{
-		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: 4}}}},
-	}
+func init() { registerGenerator(genreGenerator{}) }
 
-	cursor, err := top50Collection.Aggregate(context.Background(), pipeline)
+func (genreGenerator) ID() string      { return "genre" }
+func (genreGenerator) Name() string    { return "Genre le plus représenté" }
+func (genreGenerator) Difficulty() int { return difficultyMedium }
+
+// génère une question sur le genre le plus représenté parmi les artistes
+func (genreGenerator) Generate(ctx context.Context, db *mongo.Database, rng *rand.Rand, opts generationOptions) (QuestionTrend, error) {
+	pool, err := loadGenrePool(ctx, db)
 	if err != nil {
-		return QuestionTrend{}, fmt.Errorf("erreur lors de l'agrégation pour une tendance régionale: %w", err)
+		return QuestionTrend{}, err
 	}
-	defer cursor.Close(context.TODO())
 
-	var countryTracksList []CountryTracks
-	if err = cursor.All(context.TODO(), &countryTracksList); err != nil {
-		return QuestionTrend{}, fmt.Errorf("erreur lors de la récupération des données de tendance régionale: %w", err)
-	}
-	if len(countryTracksList) < 4 {
-		return QuestionTrend{}, fmt.Errorf("pas assez de données de tendance régionale trouvées")
-	}
+	//essaie les familles tirées au sort jusqu'à en trouver une qui donne 4 artistes valides
+	lastErr := fmt.Errorf("aucune famille de genres n'a au moins %d artistes", minGenreFamilyArtists)
+	families := pickAnswerFamilies(pool, rng)
+	for attempt := 0; attempt < len(families) && attempt < maxGenreFamilyAttempts; attempt++ {
+		group := families[attempt]
 
-	questionType := rand.Intn(2) //génère aléatoirement 0 ou 1 pour le type de question
-	var question QuestionTrend
-	question.Choices = make([]string, 0, 4)
-
-	if questionType == 0 { //question ayant pour choix un pays
-		selectedTrackIndex := rand.Intn(len(countryTracksList[0].Tracks))
-		selectedTrack := countryTracksList[0].Tracks[selectedTrackIndex]
-
-		question.Question = fmt.Sprintf("Dans quel pays la piste '%s' est-elle la plus populaire?", selectedTrack.Name)
-		question.Answer = countryTracksList[0].Country
-		//ajoute pour choix les autres nom de pays
-		for _, countryTracks := range countryTracksList[1:] {
-			question.Choices = append(question.Choices, countryTracks.Country)
-		}
-		question.Choices = append(question.Choices, question.Answer)
-
-	} else { //question ayant pour choix le nom d'une piste
-		selectedCountryTracks := countryTracksList[0]
-		question.Question = fmt.Sprintf("Quelle est la piste la plus populaire en %s?", selectedCountryTracks.Country)
-		mostPopular := selectedCountryTracks.Tracks[0] //la piste la plus populaire d'un pays est à l'indice 0 de la playlist
-		question.Answer = mostPopular.Name
-		//ajoute pour choix les autres nom de track de la playlist sélectionné
-		for i, track := range selectedCountryTracks.Tracks {
-			if i > 0 && len(question.Choices) < 3 {
-				question.Choices = append(question.Choices, track.Name)
-			}
+		// sélectionne 3 artistes de la famille principale et 1 artiste d'une autre famille
+		artists, err := sampleArtists(ctx, db, bson.M{"genre": bson.M{"$in": group.Genres}}, 3, rng, opts.Recent)
+		if err != nil {
+			return QuestionTrend{}, err
 		}
-		question.Choices = append(question.Choices, question.Answer)
-	}
-
-	rand.Shuffle(len(question.Choices), func(i, j int) { question.Choices[i], question.Choices[j] = question.Choices[j], question.Choices[i] })
+		intruder, err := sampleArtists(ctx, db, bson.M{"genre": bson.M{"$nin": group.Genres, "$exists": true, "$ne": bson.A{}}}, 1, rng, opts.Recent)
+		if err != nil {
+			return QuestionTrend{}, err
+		}
+		artists = append(artists, intruder...)
 
-	return question, nil
+		question, err := buildGenreQuestion(group.Family, artists, pool, rng)
+		if err == nil {
+			return question, nil
+		}
+		lastErr = err
+	}
+	return QuestionTrend{}, fmt.Errorf("impossible de générer une question de genre: %w", lastErr)
 }
 
-// --------------- Handler gérant les données des quizzs ---------------------
-
-// handler pour générer une question de quiz
-func generateQuizQuestionHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// types de questions sur les tendances régionales
+const (
+	// « Dans quel pays la piste X est-elle la plus populaire ? »
+	regionalCountryQuestion = iota
+	// « Quelle est la piste la plus populaire en X ? »
+	regionalTrackQuestion
+)
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
+// position de la piste dans le Top 50 ; les anciens relevés sans position gardent l'ordre de la playlist
+func chartPosition(track Track, index int) int {
+	if track.Position > 0 {
+		return track.Position
 	}
+	return index + 1
+}
 
-	var question QuestionTrend
-	client, err := connectToMongo()
-	if err != nil {
-		http.Error(w, "Erreur lors de la connexion à MongoDB: "+err.Error(), http.StatusInternalServerError)
-		log.Println(err)
-		return
+// construit une question sur les tendances régionales à partir des Top 50 de tous les pays,
+// en comparant les positions de chaque piste dans tous les classements pour que la réponse
+// soit la seule possible
+func buildRegionalTrendsQuestion(snapshots []CountryTracks, kind int, recent *recentQuestions, rng *rand.Rand) (QuestionTrend, error) {
+	var countries []string
+	seenCountries := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		if snapshot.Country != "" && len(snapshot.Tracks) > 0 && !seenCountries[snapshot.Country] {
+			seenCountries[snapshot.Country] = true
+			countries = append(countries, snapshot.Country)
+		}
 	}
-	defer client.Disconnect(context.TODO())
 
-	db := client.Database("spotifyData")
+	switch kind {
+	case regionalCountryQuestion:
+		if len(countries) < 4 {
+			return QuestionTrend{}, fmt.Errorf("pas assez de pays pour une tendance régionale (%d sur 4)", len(countries))
+		}
 
-	validQuestion := false
-	for attempts := 0; attempts < 3 && !validQuestion; attempts++ {
-		questionType := rand.Intn(3)
+		//meilleure position de chaque piste dans chaque pays
+		type charting struct {
+			name      string
+			positions map[string]int
+		}
+		tracks := make(map[string]*charting)
+		var order []string
+		for _, snapshot := range snapshots {
+			for i, track := range snapshot.Tracks {
+				key := track.ID
+				if key == "" {
+					key = track.Name
+				}
+				if key == "" || snapshot.Country == "" {
+					continue
+				}
+				entry, ok := tracks[key]
+				if !ok {
+					entry = &charting{name: track.Name, positions: make(map[string]int)}
+					tracks[key] = entry
+					order = append(order, key)
+				}
+				if position, ok := entry.positions[snapshot.Country]; !ok || chartPosition(track, i) < position {
+					entry.positions[snapshot.Country] = chartPosition(track, i)
+				}
+			}
+		}
 
-		//génère une question en fonction du type
-		switch questionType {
-		case TopArtistsQuestionType:
-			question, err = generateTopArtistsQuestion(db)
-		case GenreQuestionType:
-			question, err = generateGenreQuestion(db)
-		case RegionalTrendsQuestionType:
-			question, err = generateRegionalTrendsQuestion(db)
+		//une piste convient si un seul pays la classe à sa meilleure position ; celles présentes
+		//dans plusieurs pays donnent des questions plus intéressantes et sont préférées
+		var shared, single []string
+		for _, key := range order {
+			entry := tracks[key]
+			if entry.name == "" {
+				continue
+			}
+			best, ties := 0, 0
+			for _, position := range entry.positions {
+				if best == 0 || position < best {
+					best, ties = position, 1
+				} else if position == best {
+					ties++
+				}
+			}
+			if ties != 1 {
+				continue
+			}
+			if len(entry.positions) > 1 {
+				shared = append(shared, key)
+			} else {
+				single = append(single, key)
+			}
+		}
+		candidates := shared
+		if len(candidates) == 0 {
+			candidates = single
 		}
+		if len(candidates) == 0 {
+			return QuestionTrend{}, fmt.Errorf("aucune piste n'est la plus populaire dans un seul pays")
+		}
+		//évite les pistes vues récemment par le joueur tant qu'il en reste d'autres
+		var unseen []string
+		for _, key := range candidates {
+			if !recent.seenEntity(trackEntity(tracks[key].name)) {
+				unseen = append(unseen, key)
+			}
+		}
+		if len(unseen) > 0 {
+			candidates = unseen
+		}
+		entry := tracks[candidates[rng.Intn(len(candidates))]]
 
-		if err == nil && len(question.Choices) > 0 {
-			validQuestion = true
+		var answer string
+		for country, position := range entry.positions {
+			if answer == "" || position < entry.positions[answer] {
+				answer = country
+			}
 		}
-	}
+		runnerUp := 0
+		for country, position := range entry.positions {
+			if country != answer && (runnerUp == 0 || position < runnerUp) {
+				runnerUp = position
+			}
+		}
+		question := QuestionTrend{
+			Question:   fmt.Sprintf("Dans quel pays la piste '%s' est-elle la plus populaire?", entry.name),
+			Answer:     answer,
+			Choices:    []string{answer},
+			Difficulty: chartCountryDifficulty(entry.positions[answer], runnerUp),
+			Entities:   []string{trackEntity(entry.name)},
+		}
+		others := make([]string, 0, len(countries)-1)
+		for _, country := range countries {
+			if country != answer {
+				others = append(others, country)
+			}
+		}
+		rng.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+		question.Choices = append(question.Choices, others[:3]...)
+		rng.Shuffle(len(question.Choices), func(i, j int) { question.Choices[i], question.Choices[j] = question.Choices[j], question.Choices[i] })
+		return question, nil
+
+	case regionalTrackQuestion:
+		//pays dont le Top 50 a au moins 4 pistes de noms différents et une seule piste en tête
+		type ranked struct {
+			name     string
+			position int
+		}
+		var eligible []string
+		charts := make(map[string][]ranked)
+		for _, snapshot := range snapshots {
+			if snapshot.Country == "" || charts[snapshot.Country] != nil {
+				continue
+			}
+			//une piste présente plusieurs fois sous le même nom garde sa meilleure position
+			var chart []ranked
+			indexByName := make(map[string]int)
+			for i, track := range snapshot.Tracks {
+				if track.Name == "" {
+					continue
+				}
+				if j, ok := indexByName[track.Name]; ok {
+					if position := chartPosition(track, i); position < chart[j].position {
+						chart[j].position = position
+					}
+					continue
+				}
+				indexByName[track.Name] = len(chart)
+				chart = append(chart, ranked{track.Name, chartPosition(track, i)})
+			}
+			sort.SliceStable(chart, func(i, j int) bool { return chart[i].position < chart[j].position })
+			if len(chart) < 4 || chart[0].position == chart[1].position {
+				continue
+			}
+			charts[snapshot.Country] = chart
+			eligible = append(eligible, snapshot.Country)
+		}
+		if len(eligible) == 0 {
+			return QuestionTrend{}, fmt.Errorf("aucun Top 50 ne permet de poser une question sur la piste la plus populaire")
+		}
+		//évite les pays dont la piste en tête a été vue récemment tant qu'il en reste d'autres
+		var unseen []string
+		for _, country := range eligible {
+			if !recent.seenEntity(trackEntity(charts[country][0].name)) {
+				unseen = append(unseen, country)
+			}
+		}
+		if len(unseen) > 0 {
+			eligible = unseen
+		}
+		country := eligible[rng.Intn(len(eligible))]
+		chart := charts[country]
+
+		question := QuestionTrend{
+			Question: fmt.Sprintf("Quelle est la piste la plus populaire en %s?", country),
+			Answer:   chart[0].name,
+			Choices:  []string{chart[0].name},
+			Entities: []string{trackEntity(chart[0].name)},
+		}
+		others := append([]ranked(nil), chart[1:]...)
+		rng.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+		closest := 0
+		for _, track := range others[:3] {
+			question.Choices = append(question.Choices, track.name)
+			question.Entities = append(question.Entities, trackEntity(track.name))
+			if closest == 0 || track.position < closest {
+				closest = track.position
+			}
+		}
+		question.Difficulty = chartTrackDifficulty(closest)
+		rng.Shuffle(len(question.Choices), func(i, j int) { question.Choices[i], question.Choices[j] = question.Choices[j], question.Choices[i] })
+		return question, nil
 
-	if !validQuestion {
-		http.Error(w, "Impossible de générer une question valide après plusieurs tentatives", http.StatusInternalServerError)
-		return
+	default:
+		return QuestionTrend{}, fmt.Errorf("type de question régionale inconnu: %d", kind)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(question)
 }
 
-// handler pour finir un quiz et mettre à jour les infos de l'utilisateur
-func finishQuizHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+// générateur des questions sur la popularité d'une piste selon les pays
+type regionalTrendsGenerator struct{}
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-	tokenHeader := r.Header.Get("Authorization")
-	if tokenHeader == "" {
-		http.Error(w, "Authorization header is required", http.StatusUnauthorized)
-		return
-	}
-	splitToken := strings.Split(tokenHeader, "Bearer ")
-	if len(splitToken) != 2 {
-		http.Error(w, "Invalid Authorization token", http.StatusUnauthorized)
-		return
-	}
-	tokenString := splitToken[1]
-	claims := &jwt.StandardClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid Authorization token", http.StatusUnauthorized)
-		return
-	}
+func init() { registerGenerator(regionalTrendsGenerator{}) }
 
-	userID := strings.TrimSpace(claims.Subject)
+func (regionalTrendsGenerator) ID() string      { return "regional-trends" }
+func (regionalTrendsGenerator) Name() string    { return "Tendances régionales" }
+func (regionalTrendsGenerator) Difficulty() int { return difficultyHard }
 
-	if _, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
+// génère une question sur la popularité d'une piste dans un pays
+func (regionalTrendsGenerator) Generate(ctx context.Context, db *mongo.Database, rng *rand.Rand, opts generationOptions) (QuestionTrend, error) {
+	//récupère les Top 50 de tous les pays pour comparer les positions d'une piste entre pays
+	cursor, err := db.Collection("top50").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "country", Value: 1}}))
+	if err != nil {
+		return QuestionTrend{}, fmt.Errorf("erreur lors de la récupération des tendances régionales: %w", err)
+	}
+	var snapshots []CountryTracks
+	if err = cursor.All(ctx, &snapshots); err != nil {
+		return QuestionTrend{}, fmt.Errorf("erreur lors de la récupération des données de tendance régionale: %w", err)
+	}
 
-		//décode les données JSON de la fin du quiz
-		var quizResult QuizResult
-		err = json.NewDecoder(r.Body).Decode(&quizResult)
-		if err != nil {
-			http.Error(w, "Erreur lors de la lecture des données JSON", http.StatusBadRequest)
-			return
-		}
+	//génère aléatoirement l'un des deux types de question, puis l'autre si le premier est impossible
+	kinds := []int{regionalCountryQuestion, regionalTrackQuestion}
+	rng.Shuffle(len(kinds), func(i, j int) { kinds[i], kinds[j] = kinds[j], kinds[i] })
+	question, err := buildRegionalTrendsQuestion(snapshots, kinds[0], opts.Recent, rng)
+	if err != nil {
+		question, err = buildRegionalTrendsQuestion(snapshots, kinds[1], opts.Recent, rng)
+	}
+	return question, err
+}
 
-		client, err := connectToMongo()
-		if err != nil {
-			http.Error(w, "Erreur lors de la connexion à MongoDB", http.StatusInternalServerError)
-			log.Println(err)
-			return
+// enregistre la partie dans l'historique, ajoute son score à l'utilisateur et au classement,
+// puis enregistre son nouveau classement, le tout dans une même transaction pour que users,
+// classement et quiz_results restent cohérents
+func recordQuizScore(ctx context.Context, client *mongo.Client, userID string, result QuizResult) error {
+	db := quizzerDB(client)
+	return newTransactionRunner(client).runInTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now()
+
+		//le score des questions chronométrées est calculé à partir des réponses enregistrées
+		played := result
+		if len(result.QuestionIDs) > 0 {
+			ids := make([]primitive.ObjectID, 0, len(result.QuestionIDs))
+			for _, raw := range result.QuestionIDs {
+				if id, err := primitive.ObjectIDFromHex(raw); err == nil {
+					ids = append(ids, id)
+				}
+			}
+			score, answers, err := claimIssuedQuestions(ctx, db, userID, ids, now)
+			if err != nil {
+				return err
+			}
+			played.Score, played.Answers, played.QuestionCount = score, answers, len(answers)
 		}
-		defer client.Disconnect(context.Background())
+		score := played.Score
 
 		//mise à jour de l'utilisateur
-		collection := client.Database("spotTrendQuizzer").Collection("users")
-		filter := bson.M{"userid": userID}
-		log.Println("userID", filter)
-		// mise à jour du score total, du nombre de parties, et ajouter le score à l'historique
+		collection := db.Collection("users")
+		filter := bson.M{"userId": userID}
+		// mise à jour du score total, du nombre de parties, et ajouter le score au résumé des 5 dernières parties
 		update := bson.D{
 			{Key: "$inc", Value: bson.M{
-				"scoretotal":  quizResult.Score,
-				"nbdeparties": 1,
+				"scoreTotal":  score,
+				"nbDeParties": 1,
 			}},
 			{Key: "$push", Value: bson.M{
-				"scorehistory": bson.M{
-					"$each":  []interface{}{fmt.Sprintf("%d", quizResult.Score)},
+				"scoreHistory": bson.M{
+					"$each":  []interface{}{fmt.Sprintf("%d", score)},
 					"$slice": -5,
 				},
 			}},
 		}
-
-		_, err = collection.UpdateOne(context.Background(), filter, update)
-		if err != nil {
-			http.Error(w, "Erreur lors de la mise à jour du score de l'utilisateur", http.StatusInternalServerError)
-			log.Println(err)
-			return
+		var user User
+		if err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&user); err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du score de l'utilisateur: %w", err)
 		}
 
-		//mise à jour du score total dans la collection 'classement'
-		classementCollection := client.Database("spotTrendQuizzer").Collection("classement")
+		//mise à jour du score total dans la collection 'classement' ; scoreAchievedAt ne change
+		//que si le score total change, pour départager les ex æquo par ancienneté du score
 		classementUpdate := bson.M{
-			"$inc": bson.M{"scoreTotal": quizResult.Score},
+			"$inc": bson.M{"scoreTotal": score, "nbDeParties": 1},
+		}
+		if score != 0 {
+			classementUpdate["$set"] = bson.M{"scoreAchievedAt": now.UTC()}
+		}
+		//les groupes d'ex æquo suivent le joueur ; un joueur absent du classement (voir
+		//reconcile-scores) n'appartient à aucun groupe
+		var before UserRanking
+		err := db.Collection("classement").FindOneAndUpdate(ctx, bson.M{"userId": userID}, classementUpdate).Decode(&before)
+		if err == nil {
+			after := before
+			after.Score += score
+			after.NbDeParties++
+			if score != 0 {
+				after.ScoreAchievedAt = now.UTC()
+			}
+			err = updateScoreGroups(ctx, allTimeBoard(db), &before, &after)
+		} else if err == mongo.ErrNoDocuments {
+			err = nil
+		} else {
+			err = fmt.Errorf("erreur lors de la mise à jour du score total dans le classement: %w", err)
 		}
-		_, err = classementCollection.UpdateOne(context.Background(), bson.M{"userId": userID}, classementUpdate)
 		if err != nil {
-			http.Error(w, "Erreur lors de la mise à jour du score total dans le classement", http.StatusInternalServerError)
-			log.Println(err)
-			return
+			return err
+		}
+
+		//ajout du score aux classements du jour, de la semaine, du mois et de la saison
+		if err := recordPeriodScores(ctx, db, user, score, now); err != nil {
+			return err
 		}
 
 		//calcul du nouveau classement de l'utilisateur après la mise à jour du score total
-		userRanking, err := getRanking(client, userID)
+		userRanking, err := getRanking(ctx, allTimeBoard(db), userID)
 		if err != nil {
-			http.Error(w, "Erreur lors de la récupération du nouveau classement", http.StatusInternalServerError)
-			log.Println(err)
-			return
+			return fmt.Errorf("erreur lors de la récupération du nouveau classement: %w", err)
 		}
 
 		//mettre à jour le classement de l'utilisateur dans la collection 'users'
-		_, err = collection.UpdateOne(
-			context.Background(),
-			bson.M{"userid": userID},
-			bson.M{"$set": bson.M{"userRanking": userRanking}},
-		)
-		if err != nil {
-			http.Error(w, "Erreur lors de la mise à jour du classement de l'utilisateur", http.StatusInternalServerError)
-			log.Println(err)
+		if _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"userRanking": userRanking}}); err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du classement de l'utilisateur: %w", err)
+		}
+
+		//enregistre la partie dans l'historique, avec le rang obtenu
+		rankAfter := 0
+		for _, entry := range userRanking {
+			if entry.UserID == userID {
+				rankAfter = entry.Rank
+			}
+		}
+		return insertQuizRecord(ctx, db, userID, played, now, rankAfter)
+	})
+}
+
+// --------------- Handler gérant les données des quizzs ---------------------
+
+// lit le paramètre seed de la requête, ou en tire un au hasard s'il est absent
+func seedFromRequest(r *http.Request) (int64, *fieldError) {
+	raw := r.URL.Query().Get("seed")
+	if raw == "" {
+		return time.Now().UnixNano(), nil
+	}
+	seed, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, &fieldError{Field: "seed", Code: "type", Message: "Doit être un entier sur 64 bits"}
+	}
+	return seed, nil
+}
+
+// paramètres de génération d'une question
+type questionRequest struct {
+	Mode    string
+	Weights generatorWeights
+	Seed    int64
+	Target  int               // difficulté visée par le mode adaptive, 0 sinon
+	Options generationOptions // questions récentes du joueur à éviter
+}
+
+// lit ?mode= et ?seed= ; écrit l'erreur et renvoie false s'ils sont invalides
+func parseQuestionRequest(w http.ResponseWriter, r *http.Request) (questionRequest, bool) {
+	var errs []fieldError
+	req := questionRequest{Mode: r.URL.Query().Get("mode")}
+	if req.Mode == "" {
+		req.Mode = defaultQuizMode
+	}
+	weights, ok := quizModes[req.Mode]
+	if !ok {
+		errs = append(errs, fieldError{Field: "mode", Code: "enum", Message: "Doit être l'un des modes suivants : " + strings.Join(quizModeNames(), ", ")})
+	}
+	req.Weights = weights
+	seed, ferr := seedFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	req.Seed = seed
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
+		return questionRequest{}, false
+	}
+	return req, true
+}
+
+// génère la question demandée ; une question déjà posée récemment au joueur (req.Options.Recent)
+// est régénérée. Écrit l'erreur et renvoie false en cas d'échec
+func generateRequestedQuestion(w http.ResponseWriter, r *http.Request, client *mongo.Client, req questionRequest) (QuestionTrend, bool) {
+	rng := rand.New(rand.NewSource(req.Seed))
+	recent := req.Options.Recent
+	var question QuestionTrend
+	var err error
+	for attempt := 0; attempt < maxQuestionAttempts; attempt++ {
+		if req.Target != 0 {
+			question, err = generateQuestionNear(r.Context(), spotifyDB(client), req.Weights, req.Target, rng, req.Options)
+		} else {
+			question, err = generateQuestion(r.Context(), spotifyDB(client), req.Weights, rng, req.Options)
+		}
+		if err != nil || !recent.seenQuestion(question) {
+			break
+		}
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Warn("échec de génération de question", "mode", req.Mode, "seed", req.Seed, "error", err)
+		writeError(w, http.StatusServiceUnavailable, codeQuestionUnavailable, "Impossible de générer une question valide après plusieurs tentatives", nil)
+		return QuestionTrend{}, false
+	}
+	question.Seed = req.Seed
+	return question, true
+}
+
+// handler pour générer une question de quiz chronométrée ; ?mode= choisit la pondération des
+// types de question et ?seed= rend la question reproductible. La réponse n'est pas envoyée : le
+// joueur répond par POST /api/v1/questions/answers avant la date limite.
+func generateQuizQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := parseQuestionRequest(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	//évite les questions vues récemment par le joueur, sauf pour une graine choisie qui doit
+	//redonner la même question
+	practice := r.URL.Query().Has("seed")
+	now := time.Now()
+	if userID != "" && !practice {
+		if req.Options.Recent, err = loadRecentQuestions(r.Context(), quizzerDB(client), userID, now); err != nil {
+			loggerFrom(r.Context()).Error("erreur lors de la lecture des questions récentes", "error", err)
+			writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la lecture des questions récentes", nil)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "Mise à jour réussie")
 	}
+
+	//le mode adaptive vise la difficulté qui correspond aux dernières réponses du joueur
+	if req.Mode == adaptiveQuizMode {
+		if req.Target, err = targetDifficulty(r.Context(), quizzerDB(client), userID); err != nil {
+			loggerFrom(r.Context()).Error("erreur lors du calcul de la difficulté visée", "error", err)
+			writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors du calcul de la difficulté visée", nil)
+			return
+		}
+	}
+
+	question, ok := generateRequestedQuestion(w, r, client, req)
+	if !ok {
+		return
+	}
+	timed, err := issueQuestion(r.Context(), quizzerDB(client), userID, question, practice, now)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de l'enregistrement de la question", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de l'enregistrement de la question", nil)
+		return
+	}
+	if !practice {
+		//un échec ne bloque pas la partie : la question pourra seulement revenir plus tôt
+		if err := markQuestionSeen(r.Context(), quizzerDB(client), userID, question, now); err != nil {
+			loggerFrom(r.Context()).Warn("erreur lors de l'enregistrement de la question vue", "error", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, timed)
 }
 
-// handler appelé à la fin d'un quizz pour transmettre les infos mis à jours de l'utilisateur
-func getQuizResultHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+// handler de l'ancien chemin /generate-question : la question est renvoyée avec sa réponse et
+// n'est pas chronométrée, pour les clients qui corrigent eux-mêmes
+func legacyQuizQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := parseQuestionRequest(w, r)
+	if !ok {
+		return
+	}
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
 		return
 	}
-	tokenHeader := r.Header.Get("Authorization")
-	splitToken := strings.Split(tokenHeader, "Bearer ")
-	if len(splitToken) != 2 {
-		http.Error(w, "Invalid Authorization token", http.StatusUnauthorized)
+	defer client.Disconnect(context.Background())
+
+	//sans utilisateur, le mode adaptive vise la difficulté moyenne
+	if req.Mode == adaptiveQuizMode {
+		req.Target = difficultyMedium
+	}
+	question, ok := generateRequestedQuestion(w, r, client, req)
+	if !ok {
 		return
 	}
-	tokenString := splitToken[1]
-	claims := &jwt.StandardClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid Authorization token", http.StatusUnauthorized)
+	writeJSON(w, http.StatusOK, question)
+}
+
+// handler pour finir un quiz et mettre à jour les infos de l'utilisateur ; le score est calculé
+// par le serveur à partir des questions chronométrées, questionIds est donc obligatoire
+func finishQuizHandler(w http.ResponseWriter, r *http.Request) {
+	finishQuiz(w, r, false)
+}
+
+// handler de l'ancien chemin /finish-quizz : ses clients corrigent eux-mêmes les questions de
+// /generate-question et n'envoient pas de questionIds ; leur partie est alors enregistrée sans
+// point, le score qu'ils envoient n'étant jamais crédité
+func legacyFinishQuizHandler(w http.ResponseWriter, r *http.Request) {
+	finishQuiz(w, r, true)
+}
+
+// enregistre un quiz terminé, dont le score est celui des questions chronométrées de questionIds ;
+// legacy accepte un quiz sans questionIds
+func finishQuiz(w http.ResponseWriter, r *http.Request, legacy bool) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	//décode les données JSON de la fin du quiz
+	var quizResult QuizResult
+	if !decodeJSONBody(w, r, &quizResult, maxQuizResultBodyBytes) {
+		return
+	}
+	errs := quizResult.validate()
+	if !legacy && len(quizResult.QuestionIDs) == 0 {
+		errs = append(errs, fieldError{"questionIds", "required", "Requis : le score est calculé par le serveur à partir des questions chronométrées"})
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Le résultat du quiz est invalide", errs)
 		return
 	}
+	//le score envoyé par le client n'est jamais crédité : sans questionIds, la partie vaut 0 point
+	quizResult.Score = 0
+
 	client, err := connectToMongo()
 	if err != nil {
-		http.Error(w, "Erreur lors de la connexion à MongoDB", http.StatusInternalServerError)
-		log.Println(err)
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	//met à jour le score de l'utilisateur, le classement et le classement enregistré dans son profil
+	err = recordQuizScore(r.Context(), client, userID, quizResult)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de l'enregistrement du résultat du quiz", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la mise à jour du score de l'utilisateur", nil)
+		return
+	}
+	//prévient les clients abonnés au classement en temps réel
+	scoreChanges.publish(userID)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Mise à jour réussie")
+}
+
+// handler appelé à la fin d'un quizz pour transmettre les infos mis à jours de l'utilisateur
+func getQuizResultHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
 		return
 	}
 	defer client.Disconnect(context.Background())
 
 	//récupération des informations de l'utilisateur de la collection users
-	usersCollection := client.Database("spotTrendQuizzer").Collection("users")
+	usersCollection := quizzerDB(client).Collection("users")
 
 	var user User
-	err = usersCollection.FindOne(context.Background(), bson.M{"userid": claims.Subject}).Decode(&user)
+	err = usersCollection.FindOne(context.Background(), bson.M{"userId": userID}).Decode(&user)
 	if err != nil {
-		http.Error(w, "Erreur lors de la récupération des informations de l'utilisateur", http.StatusInternalServerError)
-		log.Println(err)
+		loggerFrom(r.Context()).Error("erreur lors de la récupération des informations de l'utilisateur", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération des informations de l'utilisateur", nil)
 		return
 	}
 
 	//récupère le classement de l'utilisateur
-	userRanking, err := getRanking(client, user.UserID)
+	userRanking, err := getRanking(r.Context(), allTimeBoard(quizzerDB(client)), user.UserID)
 	if err != nil {
-		http.Error(w, "Erreur lors de la récupération du classement", http.StatusInternalServerError)
-		log.Println(err)
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du classement", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement", nil)
 		return
 	}
 
@@ -422,7 +779,5 @@ func getQuizResultHandler(w http.ResponseWriter, r *http.Request) {
 		UserRanking: userRanking,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
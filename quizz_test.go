@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// artistes nommés d'après leur popularité
+func artistsWithPopularity(popularities ...int) []Artist {
+	artists := make([]Artist, len(popularities))
+	for i, popularity := range popularities {
+		artists[i] = Artist{ID: fmt.Sprintf("id-%d", i), Name: fmt.Sprintf("artiste-%d-%d", i, popularity), Popularity: popularity}
+	}
+	return artists
+}
+
+func TestBuildTopArtistsQuestion(t *testing.T) {
+	tests := []struct {
+		name    string
+		artists []Artist
+		wantErr bool
+	}{
+		{name: "jeu complet", artists: artistsWithPopularity(92, 15, 71, 40, 63, 88, 30, 55, 12, 77, 49, 66)},
+		{name: "popularités à égalité", artists: artistsWithPopularity(80, 80, 60, 60, 40, 20)},
+		{name: "popularités nulles ignorées", artists: artistsWithPopularity(0, 70, 0, 50, 30, 10)},
+		{name: "trop de popularités nulles", artists: artistsWithPopularity(90, 0, 0, 0, 50), wantErr: true},
+		{name: "moins de 4 artistes", artists: artistsWithPopularity(90, 50, 10), wantErr: true},
+		{name: "écart inférieur au minimum", artists: artistsWithPopularity(90, 88, 87, 86), wantErr: true},
+		{name: "égalités réduisant le nombre de choix", artists: artistsWithPopularity(70, 70, 50, 50, 30, 30), wantErr: true},
+		{name: "doublons de nom", artists: append(artistsWithPopularity(80, 60, 40), Artist{ID: "id-x", Name: "artiste-0-80", Popularity: 20}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			question, err := buildTopArtistsQuestion(tt.artists, rand.New(rand.NewSource(42)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("question %+v générée, attendu une erreur", question)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("erreur inattendue: %v", err)
+			}
+
+			byName := make(map[string]Artist)
+			for _, artist := range tt.artists {
+				byName[artist.Name] = artist
+			}
+			if len(question.Choices) != topArtistsChoices {
+				t.Fatalf("%d choix, attendu %d", len(question.Choices), topArtistsChoices)
+			}
+			answer, ok := byName[question.Answer]
+			if !ok {
+				t.Fatalf("réponse %q inconnue", question.Answer)
+			}
+			found := false
+			seen := make(map[string]bool)
+			for _, choice := range question.Choices {
+				if seen[choice] {
+					t.Fatalf("choix %q en double", choice)
+				}
+				seen[choice] = true
+				if choice == question.Answer {
+					found = true
+					continue
+				}
+				other := byName[choice]
+				if other.Popularity <= 0 {
+					t.Errorf("choix %q sans popularité", choice)
+				}
+				if answer.Popularity-other.Popularity < minPopularityGap {
+					t.Errorf("écart de %d entre %q et %q, minimum %d", answer.Popularity-other.Popularity, question.Answer, choice, minPopularityGap)
+				}
+			}
+			if !found {
+				t.Fatalf("la réponse %q n'est pas parmi les choix %v", question.Answer, question.Choices)
+			}
+		})
+	}
+}
+
+// une même graine redonne la même question
+func TestBuildTopArtistsQuestionIsDeterministic(t *testing.T) {
+	artists := artistsWithPopularity(92, 15, 71, 40, 63, 88, 30, 55, 12, 77, 49, 66)
+	first, err := buildTopArtistsQuestion(artists, rand.New(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := buildTopArtistsQuestion(artists, rand.New(rand.NewSource(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Fatalf("questions différentes pour la même graine : %+v et %+v", first, second)
+	}
+}
+
+func TestGenreFamily(t *testing.T) {
+	tests := []struct {
+		genre string
+		want  string
+	}{
+		{genre: "french hip hop", want: "hip hop"},
+		{genre: "pop rock", want: "rock"},
+		{genre: "k-pop", want: "pop"},
+		{genre: "dance pop", want: "pop"},
+		{genre: " Dance Pop ", want: "pop"},
+		{genre: "hip hop", want: "hip hop"},
+		{genre: "latin trap", want: "trap"},
+		{genre: "synthpop", want: "synthpop"},
+		{genre: "shoegaze", want: "shoegaze"},
+		{genre: "pop punk", want: "pop punk"},
+	}
+	for _, tt := range tests {
+		if got := genreFamily(tt.genre); got != tt.want {
+			t.Errorf("genreFamily(%q) = %q, attendu %q", tt.genre, got, tt.want)
+		}
+	}
+}
+
+// quand plusieurs familles terminent le genre sur une frontière de mot, la plus longue l'emporte
+func TestGenreFamilyPrefersLongestFamily(t *testing.T) {
+	previous := genreFamilies
+	defer func() { genreFamilies = previous }()
+	for _, families := range [][]string{{"hop", "hip hop"}, {"hip hop", "hop"}} {
+		genreFamilies = families
+		if got := genreFamily("french hip hop"); got != "hip hop" {
+			t.Errorf("familles %v : genreFamily(%q) = %q, attendu %q", families, "french hip hop", got, "hip hop")
+		}
+	}
+}
+
+func TestBuildGenrePool(t *testing.T) {
+	pool := buildGenrePool(map[string]int{
+		"french hip hop": 4,
+		"hip hop":        3,
+		"dance pop":      5,
+		"k-pop":          2,
+		"shoegaze":       1,
+		"":               7,
+		"pop rock":       0,
+	})
+	want := []genreGroup{
+		{Family: "hip hop", Genres: []string{"french hip hop", "hip hop"}, Artists: 7},
+		{Family: "pop", Genres: []string{"dance pop", "k-pop"}, Artists: 7},
+		{Family: "shoegaze", Genres: []string{"shoegaze"}, Artists: 1},
+	}
+	if !reflect.DeepEqual(pool, want) {
+		t.Fatalf("pool = %+v, attendu %+v", pool, want)
+	}
+}
+
+func TestPickAnswerFamilies(t *testing.T) {
+	pool := []genreGroup{
+		{Family: "pop", Artists: 1000},
+		{Family: "rock", Artists: minGenreFamilyArtists},
+		{Family: "jazz", Artists: minGenreFamilyArtists - 1},
+		{Family: "shoegaze", Artists: 1},
+	}
+	popFirst := 0
+	for seed := int64(0); seed < 100; seed++ {
+		picked := pickAnswerFamilies(pool, rand.New(rand.NewSource(seed)))
+		families := make([]string, len(picked))
+		for i, group := range picked {
+			families[i] = group.Family
+		}
+		sorted := append([]string(nil), families...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(sorted, []string{"pop", "rock"}) {
+			t.Fatalf("graine %d : familles %v, attendu pop et rock seulement", seed, families)
+		}
+		if families[0] == "pop" {
+			popFirst++
+		}
+	}
+	//le tirage est pondéré par le nombre d'artistes
+	if popFirst < 90 {
+		t.Errorf("pop tirée en premier %d fois sur 100, attendu presque toujours", popFirst)
+	}
+}
+
+// artiste de test ayant les genres donnés
+func artistWithGenres(name string, genres ...string) Artist {
+	return Artist{ID: "id-" + name, Name: name, Popularity: 50, Genre: genres}
+}
+
+func TestBuildGenreQuestion(t *testing.T) {
+	pool := []genreGroup{{Family: "pop"}, {Family: "rock"}, {Family: "jazz"}, {Family: "metal"}, {Family: "indie"}}
+	popArtists := []Artist{
+		artistWithGenres("a", "dance pop"),
+		artistWithGenres("b", "k-pop", "indie"),
+		artistWithGenres("c", "pop"),
+	}
+
+	tests := []struct {
+		name    string
+		artists []Artist
+		pool    []genreGroup
+		wantErr bool
+	}{
+		{name: "question valide", artists: append(popArtists, artistWithGenres("d", "pop rock")), pool: pool},
+		{
+			name: "famille partagée par 3 artistes exclue des choix",
+			artists: []Artist{
+				artistWithGenres("a", "dance pop", "indie"),
+				artistWithGenres("b", "k-pop", "indie"),
+				artistWithGenres("c", "pop", "indie"),
+				artistWithGenres("d", "metal"),
+			},
+			pool: pool,
+		},
+		{name: "intrus de la même famille", artists: append(popArtists, artistWithGenres("d", "indie pop")), pool: pool, wantErr: true},
+		{
+			name:    "artiste hors de la famille",
+			artists: []Artist{popArtists[0], popArtists[1], artistWithGenres("c", "jazz"), artistWithGenres("d", "rock")},
+			pool:    pool,
+			wantErr: true,
+		},
+		{name: "moins de 4 artistes", artists: popArtists, pool: pool, wantErr: true},
+		{name: "pas assez de familles", artists: append(popArtists, artistWithGenres("d", "rock")), pool: pool[:3], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(0); seed < 20; seed++ {
+				question, err := buildGenreQuestion("pop", tt.artists, tt.pool, rand.New(rand.NewSource(seed)))
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("question %+v générée, attendu une erreur", question)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("erreur inattendue: %v", err)
+				}
+				if question.Answer != "pop" || question.Genre != "pop" {
+					t.Fatalf("réponse %q (genre %q), attendu pop", question.Answer, question.Genre)
+				}
+				if len(question.Choices) != 4 {
+					t.Fatalf("%d choix, attendu 4", len(question.Choices))
+				}
+
+				//aucun autre choix que la réponse n'est partagé par 3 artistes
+				shared := make(map[string]int)
+				for _, artist := range tt.artists {
+					for family := range artistFamilies(artist) {
+						shared[family]++
+					}
+				}
+				seen := make(map[string]bool)
+				for _, choice := range question.Choices {
+					if seen[choice] {
+						t.Fatalf("choix %q en double", choice)
+					}
+					seen[choice] = true
+					if choice != question.Answer && shared[choice] >= 3 {
+						t.Fatalf("graine %d : le choix %q est partagé par %d artistes", seed, choice, shared[choice])
+					}
+				}
+				if !seen["pop"] {
+					t.Fatalf("la réponse n'est pas parmi les choix %v", question.Choices)
+				}
+				//la famille de l'intrus est proposée
+				for family := range artistFamilies(tt.artists[3]) {
+					if !seen[family] {
+						t.Fatalf("graine %d : la famille %q de l'intrus n'est pas proposée (%v)", seed, family, question.Choices)
+					}
+				}
+			}
+		})
+	}
+}
+
+// la piste en tête d'un pays vue récemment par le joueur n'est plus proposée comme réponse
+func TestBuildRegionalTrendsQuestionAvoidsRecentTracks(t *testing.T) {
+	var snapshots []CountryTracks
+	for _, country := range []string{"France", "USA", "Germany", "Spain"} {
+		leader := "tube"
+		if country == "USA" {
+			leader = "hit"
+		}
+		var tracks []Track
+		for i, name := range []string{leader, "b", "c", "d", "e"} {
+			tracks = append(tracks, Track{Name: name, Position: i + 1})
+		}
+		snapshots = append(snapshots, CountryTracks{Country: country, Tracks: tracks})
+	}
+	recent := &recentQuestions{entities: map[string]bool{trackEntity("tube"): true}}
+
+	for seed := int64(0); seed < 20; seed++ {
+		question, err := buildRegionalTrendsQuestion(snapshots, regionalTrackQuestion, recent, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if question.Answer != "hit" {
+			t.Fatalf("graine %d : réponse %q déjà vue, attendu %q", seed, question.Answer, "hit")
+		}
+	}
+}
+
+// le chemin versionné exige questionIds ; l'ancien chemin accepte un quiz sans questionIds (il
+// est enregistré sans point) ; un corps trop volumineux est refusé. MongoDB est rendu
+// injoignable : un quiz accepté échoue ensuite à l'enregistrement.
+func TestFinishQuizValidation(t *testing.T) {
+	previous := mongoURI
+	mongoURI = "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=50&connectTimeoutMS=50"
+	defer func() { mongoURI = previous }()
+	//sans serveur, WithTransaction réessaierait les erreurs transitoires pendant deux minutes
+	t.Setenv("MONGO_TRANSACTIONS", "off")
+	token, err := generateToken("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		want    int
+	}{
+		{name: "questionIds manquant", handler: finishQuizHandler, body: `{"Score": 500, "questionCount": 5}`, want: http.StatusBadRequest},
+		{name: "ancien chemin sans questionIds", handler: legacyFinishQuizHandler, body: `{"Score": 500, "questionCount": 5}`, want: http.StatusInternalServerError},
+		{name: "champ inconnu", handler: legacyFinishQuizHandler, body: `{"bonus": 500}`, want: http.StatusBadRequest},
+		{name: "corps trop volumineux", handler: finishQuizHandler, body: `{"mode": "` + strings.Repeat("x", maxQuizResultBodyBytes) + `"}`, want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			req := httptest.NewRequest(http.MethodPost, "/finish-quizz", strings.NewReader(tt.body)).WithContext(ctx)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("statut %d, attendu %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package ranking attribue les rangs d'un classement trié par score décroissant.
+//
+// Trois méthodes sont prises en charge pour les égalités :
+//
+//	Dense       : 1, 2, 2, 3  (les ex æquo partagent le rang, sans trou)
+//	Competition : 1, 2, 2, 4  (les ex æquo partagent le rang, avec un trou)
+//	Ordinal     : 1, 2, 3, 4  (chaque joueur a un rang distinct)
+//
+// Deux joueurs sont ex æquo lorsqu'ils ont le même score et les mêmes valeurs pour
+// chacun des critères de départage configurés. L'identifiant du joueur termine toujours
+// l'ordre pour qu'il soit déterministe, mais ne crée jamais de rang distinct, sauf en Ordinal.
+package ranking
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Method décrit comment les ex æquo se partagent les rangs
+type Method string
+
+const (
+	Dense       Method = "dense"
+	Competition Method = "competition"
+	Ordinal     Method = "ordinal"
+)
+
+// TieBreaker départage deux joueurs ayant le même score
+type TieBreaker string
+
+const (
+	// EarlierScore classe devant le joueur qui a atteint son score le plus tôt
+	EarlierScore TieBreaker = "earlier-score"
+	// FewerGames classe devant le joueur qui a joué le moins de parties
+	FewerGames TieBreaker = "fewer-games"
+)
+
+// Entry est un joueur du classement
+type Entry struct {
+	ID          string
+	Score       int
+	AchievedAt  time.Time
+	GamesPlayed int
+}
+
+// Config regroupe la méthode de classement et les critères de départage, dans l'ordre
+type Config struct {
+	Method      Method
+	TieBreakers []TieBreaker
+}
+
+// Start situe le premier joueur d'une portion contiguë du classement : Rank est son rang
+// selon la méthode, Position sa position (à partir de 1) dans l'ordre complet du classement
+type Start struct {
+	Rank     int
+	Position int
+}
+
+// Top est le point de départ d'un classement lu depuis le premier joueur
+var Top = Start{Rank: 1, Position: 1}
+
+// ParseMethod lit une méthode de classement ; une chaîne vide donne Dense
+func ParseMethod(s string) (Method, error) {
+	switch m := Method(strings.TrimSpace(strings.ToLower(s))); m {
+	case "":
+		return Dense, nil
+	case Dense, Competition, Ordinal:
+		return m, nil
+	default:
+		return "", fmt.Errorf("méthode de classement inconnue: %q", s)
+	}
+}
+
+// ParseTieBreakers lit une liste de critères séparés par des virgules
+func ParseTieBreakers(s string) ([]TieBreaker, error) {
+	var tieBreakers []TieBreaker
+	for _, part := range strings.Split(s, ",") {
+		switch tb := TieBreaker(strings.TrimSpace(strings.ToLower(part))); tb {
+		case "":
+		case EarlierScore, FewerGames:
+			tieBreakers = append(tieBreakers, tb)
+		default:
+			return nil, fmt.Errorf("critère de départage inconnu: %q", part)
+		}
+	}
+	return tieBreakers, nil
+}
+
+// compare renvoie -1 si a est classé devant b, 1 s'il est derrière et 0 s'ils sont ex æquo,
+// sans tenir compte de l'identifiant
+func (c Config) compare(a, b Entry) int {
+	if a.Score != b.Score {
+		if a.Score > b.Score {
+			return -1
+		}
+		return 1
+	}
+	for _, tb := range c.TieBreakers {
+		switch tb {
+		case EarlierScore:
+			if !a.AchievedAt.Equal(b.AchievedAt) {
+				if a.AchievedAt.Before(b.AchievedAt) {
+					return -1
+				}
+				return 1
+			}
+		case FewerGames:
+			if a.GamesPlayed != b.GamesPlayed {
+				if a.GamesPlayed < b.GamesPlayed {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// Tied indique si a et b partagent le même rang en Dense et en Competition
+func (c Config) Tied(a, b Entry) bool {
+	return c.compare(a, b) == 0
+}
+
+// Less indique si a est classé devant b ; l'identifiant départage les ex æquo
+func (c Config) Less(a, b Entry) bool {
+	if cmp := c.compare(a, b); cmp != 0 {
+		return cmp < 0
+	}
+	return a.ID < b.ID
+}
+
+// Sort trie les joueurs dans l'ordre du classement
+func (c Config) Sort(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool { return c.Less(entries[i], entries[j]) })
+}
+
+// Assign renvoie les rangs d'une portion contiguë et triée du classement dont le premier
+// joueur est situé par start (Top pour un classement lu depuis le début)
+func (c Config) Assign(entries []Entry, start Start) []int {
+	ranks := make([]int, len(entries))
+	for i := range entries {
+		switch {
+		case i == 0:
+			ranks[i] = start.Rank
+		case c.Method == Ordinal:
+			ranks[i] = start.Position + i
+		case c.Tied(entries[i-1], entries[i]):
+			ranks[i] = ranks[i-1]
+		case c.Method == Competition:
+			ranks[i] = start.Position + i
+		default:
+			ranks[i] = ranks[i-1] + 1
+		}
+	}
+	return ranks
+}
+
+// Rank trie les joueurs et renvoie leurs rangs dans cet ordre
+func (c Config) Rank(entries []Entry) []int {
+	c.Sort(entries)
+	return c.Assign(entries, Top)
+}
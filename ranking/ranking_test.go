@@ -0,0 +1,153 @@
+package ranking
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func at(minutes int) time.Time {
+	return time.Date(2024, 1, 1, 0, minutes, 0, 0, time.UTC)
+}
+
+func TestRank(t *testing.T) {
+	// a et b sont ex æquo au score ; b a atteint son score plus tôt, a a joué moins de parties
+	entries := []Entry{
+		{ID: "c", Score: 50, AchievedAt: at(3), GamesPlayed: 4},
+		{ID: "a", Score: 80, AchievedAt: at(2), GamesPlayed: 1},
+		{ID: "d", Score: 10, AchievedAt: at(4), GamesPlayed: 2},
+		{ID: "b", Score: 80, AchievedAt: at(1), GamesPlayed: 5},
+		{ID: "e", Score: 50, AchievedAt: at(3), GamesPlayed: 4},
+	}
+
+	tests := []struct {
+		name      string
+		config    Config
+		wantOrder []string
+		wantRanks []int
+	}{
+		{
+			name:      "dense avec ex æquo",
+			config:    Config{Method: Dense},
+			wantOrder: []string{"a", "b", "c", "e", "d"},
+			wantRanks: []int{1, 1, 2, 2, 3},
+		},
+		{
+			name:      "competition avec ex æquo",
+			config:    Config{Method: Competition},
+			wantOrder: []string{"a", "b", "c", "e", "d"},
+			wantRanks: []int{1, 1, 3, 3, 5},
+		},
+		{
+			name:      "ordinal avec ex æquo",
+			config:    Config{Method: Ordinal},
+			wantOrder: []string{"a", "b", "c", "e", "d"},
+			wantRanks: []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:      "dense départagé par le score le plus ancien",
+			config:    Config{Method: Dense, TieBreakers: []TieBreaker{EarlierScore}},
+			wantOrder: []string{"b", "a", "c", "e", "d"},
+			wantRanks: []int{1, 2, 3, 3, 4},
+		},
+		{
+			name:      "competition départagé par le moins de parties",
+			config:    Config{Method: Competition, TieBreakers: []TieBreaker{FewerGames}},
+			wantOrder: []string{"a", "b", "c", "e", "d"},
+			wantRanks: []int{1, 2, 3, 3, 5},
+		},
+		{
+			name:      "critères appliqués dans l'ordre configuré",
+			config:    Config{Method: Dense, TieBreakers: []TieBreaker{FewerGames, EarlierScore}},
+			wantOrder: []string{"a", "b", "c", "e", "d"},
+			wantRanks: []int{1, 2, 3, 3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := append([]Entry(nil), entries...)
+			ranks := tt.config.Rank(sorted)
+			order := make([]string, len(sorted))
+			for i, entry := range sorted {
+				order[i] = entry.ID
+			}
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("ordre = %v, attendu %v", order, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(ranks, tt.wantRanks) {
+				t.Errorf("rangs = %v, attendu %v", ranks, tt.wantRanks)
+			}
+		})
+	}
+}
+
+// une page lue au milieu du classement reprend les rangs à partir de son premier joueur
+func TestAssignFromStart(t *testing.T) {
+	// page commençant à la 6e position ; son premier joueur est 4e en dense, 5e en competition
+	page := []Entry{
+		{ID: "f", Score: 40},
+		{ID: "g", Score: 40},
+		{ID: "h", Score: 30},
+		{ID: "i", Score: 20},
+		{ID: "j", Score: 20},
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		start  Start
+		want   []int
+	}{
+		{name: "dense", config: Config{Method: Dense}, start: Start{Rank: 4, Position: 6}, want: []int{4, 4, 5, 6, 6}},
+		{name: "competition", config: Config{Method: Competition}, start: Start{Rank: 5, Position: 6}, want: []int{5, 5, 8, 9, 9}},
+		{name: "ordinal", config: Config{Method: Ordinal}, start: Start{Rank: 6, Position: 6}, want: []int{6, 7, 8, 9, 10}},
+		{name: "top", config: Config{Method: Competition}, start: Top, want: []int{1, 1, 3, 4, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.Assign(page, tt.start); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rangs = %v, attendu %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMethod(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Method
+		wantErr bool
+	}{
+		{in: "", want: Dense},
+		{in: " Competition ", want: Competition},
+		{in: "ordinal", want: Ordinal},
+		{in: "olympique", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMethod(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMethod(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestParseTieBreakers(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []TieBreaker
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "earlier-score, FEWER-GAMES", want: []TieBreaker{EarlierScore, FewerGames}},
+		{in: "fewer-games,,", want: []TieBreaker{FewerGames}},
+		{in: "random", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTieBreakers(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("ParseTieBreakers(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// écart détecté entre users et classement pour un utilisateur
+type scoreDrift struct {
+	UserID          string `bson:"userId"`
+	Pseudo          string `bson:"pseudo"`
+	UserScore       int    `bson:"scoreTotal"`
+	NbDeParties     int    `bson:"nbDeParties"`
+	Country         string `bson:"country"`
+	ClassementScore *int   `bson:"classementScore"`
+}
+
+// liste les utilisateurs dont le score total diffère entre users et classement,
+// ou qui n'ont pas d'entrée dans classement
+func findScoreDrifts(ctx context.Context, db *mongo.Database) ([]scoreDrift, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "classement"},
+			{Key: "localField", Value: "userId"},
+			{Key: "foreignField", Value: "userId"},
+			{Key: "as", Value: "classement"},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "pseudo", Value: 1},
+			{Key: "scoreTotal", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$scoreTotal", 0}}}},
+			{Key: "nbDeParties", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$nbDeParties", 0}}}},
+			{Key: "country", Value: 1},
+			{Key: "classementScore", Value: bson.D{{Key: "$first", Value: "$classement.scoreTotal"}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.D{
+			{Key: "$ne", Value: bson.A{"$scoreTotal", "$classementScore"}},
+		}}}}},
+	}
+
+	cursor, err := db.Collection("users").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la comparaison des scores: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var drifts []scoreDrift
+	if err = cursor.All(ctx, &drifts); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des écarts de score: %w", err)
+	}
+	return drifts, nil
+}
+
+// répare les écarts entre users.scoreTotal et classement.scoreTotal ; users fait foi car
+// c'est la collection mise à jour en premier et celle qui porte l'historique des parties
+func reconcileScores(ctx context.Context, dryRun bool) error {
+	client, err := connectToMongo()
+	if err != nil {
+		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	db := quizzerDB(client)
+	drifts, err := findScoreDrifts(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, drift := range drifts {
+		classementScore := "absent"
+		if drift.ClassementScore != nil {
+			classementScore = fmt.Sprint(*drift.ClassementScore)
+		}
+		fmt.Printf("%s\t%s\tusers=%d\tclassement=%s\n", drift.UserID, drift.Pseudo, drift.UserScore, classementScore)
+		if dryRun {
+			continue
+		}
+
+		err := newTransactionRunner(client).runInTransaction(ctx, func(ctx context.Context) error {
+			if drift.ClassementScore == nil {
+				entry := UserRanking{
+					UserID:          drift.UserID,
+					Pseudo:          drift.Pseudo,
+					Score:           drift.UserScore,
+					NbDeParties:     drift.NbDeParties,
+					Country:         drift.Country,
+					ScoreAchievedAt: time.Now().UTC(),
+				}
+				if _, err := db.Collection("classement").InsertOne(ctx, entry); err != nil {
+					return err
+				}
+				return updateScoreGroups(ctx, allTimeBoard(db), nil, &entry)
+			}
+			var before UserRanking
+			err := db.Collection("classement").FindOneAndUpdate(ctx,
+				bson.M{"userId": drift.UserID},
+				bson.M{"$set": bson.M{"scoreTotal": drift.UserScore, "pseudo": drift.Pseudo}},
+			).Decode(&before)
+			if err != nil {
+				return err
+			}
+			after := before
+			after.Score = drift.UserScore
+			return updateScoreGroups(ctx, allTimeBoard(db), &before, &after)
+		})
+		if err != nil {
+			return fmt.Errorf("erreur lors de la réparation du score de %s: %w", drift.UserID, err)
+		}
+	}
+
+	fmt.Printf("%d écarts trouvés", len(drifts))
+	if dryRun {
+		fmt.Println(" (aucune modification, -dry-run)")
+	} else {
+		fmt.Println(", corrigés")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// en-têtes que les clients peuvent envoyer lors d'une requête cross-origin
+const corsAllowedHeaders = "Content-Type, Authorization, X-Request-ID"
+
+// associe chaque chemin aux méthodes HTTP acceptées et à leur handler
+type router struct {
+	mux    *http.ServeMux
+	routes map[string]map[string]http.HandlerFunc
+}
+
+func newRouter() *router {
+	rt := &router{
+		mux:    http.NewServeMux(),
+		routes: make(map[string]map[string]http.HandlerFunc),
+	}
+	rt.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Ressource introuvable", nil)
+	})
+	return rt
+}
+
+// enregistre le handler pour la méthode et le chemin donnés
+func (rt *router) handle(method, path string, h http.HandlerFunc) {
+	methods, ok := rt.routes[path]
+	if !ok {
+		methods = make(map[string]http.HandlerFunc)
+		rt.routes[path] = methods
+		rt.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			rt.dispatch(methods, w, r)
+		})
+	}
+	methods[method] = h
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// appelle le handler correspondant à la méthode, répond aux requêtes OPTIONS
+// et rejette les méthodes non prises en charge
+func (rt *router) dispatch(methods map[string]http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	if h, ok := methods[r.Method]; ok {
+		h(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(methods)+1)
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	slices.Sort(allowed)
+	allowed = append(allowed, http.MethodOptions)
+	allow := strings.Join(allowed, ", ")
+	w.Header().Set("Allow", allow)
+
+	if r.Method == http.MethodOptions {
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Méthode non autorisée", map[string]interface{}{
+		"method":  r.Method,
+		"allowed": allowed,
+	})
+}
+
+// lit la liste des origines autorisées dans CORS_ALLOWED_ORIGINS (séparées par des virgules) ;
+// sans configuration, toutes les origines sont acceptées comme auparavant
+func corsAllowedOrigins() []string {
+	raw := os.Getenv("CORS_ALLOWED_ORIGINS")
+	if strings.TrimSpace(raw) == "" {
+		return []string{"*"}
+	}
+	var origins []string
+	for _, origin := range strings.Split(raw, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return origins
+}
+
+// middleware qui ajoute les en-têtes CORS pour les origines autorisées et
+// refuse les requêtes préliminaires venant d'autres origines
+func withCORS(allowedOrigins []string, next http.Handler) http.Handler {
+	wildcard := slices.Contains(allowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		switch {
+		case slices.Contains(allowedOrigins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case wildcard:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			if r.Method == http.MethodOptions {
+				writeError(w, http.StatusForbidden, codeOriginNotAllowed, "Origine non autorisée", map[string]string{"origin": origin})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import "net/http"
+
+// préfixe commun à toutes les routes de l'API versionnée
+const apiPrefix = "/api/v1"
+
+// décrit une route de l'API : son handler, son ancien chemin éventuel et
+// les informations publiées dans la spécification OpenAPI
+type apiRoute struct {
+	Method        string
+	Path          string
+	LegacyPath    string
+	Handler       http.HandlerFunc
+	LegacyHandler http.HandlerFunc // handler de l'ancien chemin, si sa réponse diffère
+	OperationID   string
+	Summary       string
+	Tag           string
+	Auth          bool
+	Params        []openAPIParam
+	Request       string
+	Status        int
+	Response      string
+}
+
+// paramètres de choix de la période d'un classement
+var periodParams = []openAPIParam{
+	{Name: "period", In: "query", Type: "string", Description: "all-time (par défaut), daily, weekly, monthly ou season"},
+	{Name: "key", In: "query", Type: "string", Description: "Période voulue (2006-01-02, 2006-W01, 2006-01 ou identifiant de saison), en cours par défaut"},
+}
+
+// paramètre de filtre des classements par pays
+var countryParam = openAPIParam{Name: "country", In: "query", Type: "string", Description: "Restreint le classement aux joueurs de ce pays"}
+
+// liste de toutes les routes exposées par le serveur
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			Method: http.MethodPost, Path: "/users", LegacyPath: "/signup",
+			Handler: signUpHandler, OperationID: "createUser", Summary: "Inscrit un nouvel utilisateur", Tag: "users",
+			Request: "SignUpRequest", Status: http.StatusCreated, Response: "Message",
+		},
+		{
+			Method: http.MethodPost, Path: "/sessions", LegacyPath: "/signin",
+			Handler: signInHandler, OperationID: "createSession", Summary: "Connecte un utilisateur et renvoie un token JWT", Tag: "users",
+			Request: "SignInRequest", Status: http.StatusOK, Response: "Token",
+		},
+		{
+			Method: http.MethodGet, Path: "/me", LegacyPath: "/userinfo",
+			Handler: userInfoHandler, OperationID: "getCurrentUser", Summary: "Renvoie le profil et le classement de l'utilisateur connecté", Tag: "users",
+			Auth: true, Status: http.StatusOK, Response: "UserInfo",
+		},
+		{
+			Method: http.MethodPatch, Path: "/me",
+			Handler: updateProfileHandler, OperationID: "updateCurrentUser", Summary: "Modifie le profil (pays) de l'utilisateur connecté", Tag: "users",
+			Auth: true, Request: "ProfileUpdate", Status: http.StatusOK, Response: "Message",
+		},
+		{
+			Method: http.MethodGet, Path: "/me/ranking", LegacyPath: "/get-result",
+			Handler: getQuizResultHandler, OperationID: "getCurrentUserRanking", Summary: "Renvoie le score total et le classement de l'utilisateur connecté", Tag: "leaderboard",
+			Auth: true, Status: http.StatusOK, Response: "QuizResultSummary",
+		},
+		{
+			Method: http.MethodPost, Path: "/me/quiz-results", LegacyPath: "/finish-quizz",
+			Handler: finishQuizHandler, LegacyHandler: legacyFinishQuizHandler,
+			OperationID: "submitQuizResult", Summary: "Enregistre un quiz terminé, dont le score est calculé par le serveur à partir de ses questions chronométrées", Tag: "quiz",
+			Auth: true, Request: "QuizResult", Status: http.StatusOK, Response: "PlainText",
+		},
+		{
+			Method: http.MethodGet, Path: "/me/stats",
+			Handler: statsHandler, OperationID: "getCurrentUserStats", Summary: "Renvoie les statistiques de l'utilisateur connecté, calculées sur ses parties", Tag: "quiz",
+			Auth: true,
+			Params: []openAPIParam{
+				{Name: "days", In: "query", Type: "integer", Description: "Nombre de jours couverts par gamesPerDay et rankHistory (1 à 365, 30 par défaut)"},
+			},
+			Status: http.StatusOK, Response: "PlayerStats",
+		},
+		{
+			Method: http.MethodGet, Path: "/me/history",
+			Handler: historyHandler, OperationID: "getCurrentUserHistory", Summary: "Renvoie les parties de l'utilisateur connecté, de la plus récente à la plus ancienne", Tag: "quiz",
+			Auth: true,
+			Params: []openAPIParam{
+				{Name: "from", In: "query", Type: "string", Description: "Début inclus (2006-01-02 ou RFC 3339)"},
+				{Name: "to", In: "query", Type: "string", Description: "Fin exclue (2006-01-02 ou RFC 3339)"},
+				{Name: "limit", In: "query", Type: "integer", Description: "Nombre de parties (1 à 100, 20 par défaut)"},
+				{Name: "cursor", In: "query", Type: "string", Description: "Curseur nextCursor de la page précédente"},
+			},
+			Status: http.StatusOK, Response: "HistoryPage",
+		},
+		{
+			Method: http.MethodGet, Path: "/leaderboard/top", LegacyPath: "/topPlayers",
+			Handler: topPlayersHandler, OperationID: "getTopPlayers", Summary: "Renvoie les meilleurs joueurs du classement", Tag: "leaderboard",
+			Params: append(periodParams[:2:2], countryParam,
+				openAPIParam{Name: "perCountry", In: "query", Type: "boolean", Description: "true pour renvoyer les meilleurs joueurs de chaque pays"},
+			),
+			Status: http.StatusOK, Response: "TopPlayers",
+		},
+		{
+			Method: http.MethodGet, Path: "/leaderboard",
+			Handler: leaderboardHandler, OperationID: "getLeaderboard", Summary: "Parcourt le classement complet par offset ou par curseur", Tag: "leaderboard",
+			Params: []openAPIParam{
+				{Name: "limit", In: "query", Type: "integer", Description: "Nombre de joueurs (1 à 100, 20 par défaut)"},
+				{Name: "offset", In: "query", Type: "integer", Description: "Nombre de joueurs à sauter"},
+				{Name: "cursor", In: "query", Type: "string", Description: "Curseur nextCursor de la page précédente"},
+				periodParams[0], periodParams[1], countryParam,
+			},
+			Status: http.StatusOK, Response: "LeaderboardPage",
+		},
+		{
+			Method: http.MethodGet, Path: "/leaderboard/around-me",
+			Handler: aroundMeHandler, OperationID: "getLeaderboardAroundMe", Summary: "Renvoie les joueurs classés autour de l'utilisateur connecté", Tag: "leaderboard",
+			Auth: true,
+			Params: []openAPIParam{
+				{Name: "radius", In: "query", Type: "integer", Description: "Nombre de joueurs au-dessus et en dessous (0 à 25, 2 par défaut)"},
+				periodParams[0], periodParams[1], countryParam,
+			},
+			Status: http.StatusOK, Response: "AroundMe",
+		},
+		{
+			Method: http.MethodGet, Path: "/leaderboard/stream",
+			Handler: leaderboardStreamHandler, OperationID: "streamLeaderboard", Summary: "Diffuse en Server-Sent Events les changements du top N et du rang de l'utilisateur", Tag: "leaderboard",
+			Params: []openAPIParam{
+				{Name: "top", In: "query", Type: "integer", Description: "Nombre de joueurs du top diffusé (1 à 50, 5 par défaut)"},
+				periodParams[0], periodParams[1], countryParam,
+				{Name: "token", In: "query", Type: "string", Description: "Token JWT, pour les clients EventSource qui ne peuvent pas envoyer l'en-tête Authorization"},
+			},
+			Status: http.StatusOK, Response: "EventStream",
+		},
+		{
+			Method: http.MethodGet, Path: "/seasons",
+			Handler: seasonsHandler, OperationID: "listSeasons", Summary: "Liste les saisons, de la plus récente à la plus ancienne", Tag: "leaderboard",
+			Status: http.StatusOK, Response: "SeasonList",
+		},
+		{
+			Method: http.MethodGet, Path: "/seasons/standings",
+			Handler: seasonStandingsHandler, OperationID: "getSeasonStandings", Summary: "Renvoie le classement final archivé d'une saison terminée", Tag: "leaderboard",
+			Params: []openAPIParam{
+				{Name: "season", In: "query", Required: true, Type: "string", Description: "Identifiant de la saison"},
+				{Name: "limit", In: "query", Type: "integer", Description: "Nombre de joueurs (1 à 100, 20 par défaut)"},
+				{Name: "offset", In: "query", Type: "integer", Description: "Nombre de joueurs à sauter"},
+			},
+			Status: http.StatusOK, Response: "LeaderboardPage",
+		},
+		{
+			Method: http.MethodGet, Path: "/questions/random", LegacyPath: "/generate-question",
+			Handler: generateQuizQuestionHandler, LegacyHandler: legacyQuizQuestionHandler,
+			OperationID: "getRandomQuestion", Summary: "Génère une question de quiz chronométrée, sans sa réponse (token facultatif : la question est alors réservée à l'utilisateur et évite les artistes et pistes qu'il a vus pendant QUESTION_COOLDOWN)", Tag: "quiz",
+			Params: []openAPIParam{
+				{Name: "mode", In: "query", Type: "string", Description: "Mode de quiz qui pondère les types de question (classic par défaut, adaptive, artists, charts ou un mode de QUIZ_MODES) ; adaptive ajuste la difficulté aux dernières réponses du joueur connecté"},
+				{Name: "seed", In: "query", Type: "integer", Description: "Graine du tirage : une même graine sur les mêmes données redonne la même question, qui ne rapporte alors aucun point et peut avoir déjà été vue (aléatoire par défaut)"},
+			},
+			Status: http.StatusOK, Response: "TimedQuestion",
+		},
+		{
+			Method: http.MethodPost, Path: "/questions/answers",
+			Handler: answerQuestionHandler, OperationID: "answerQuestion", Summary: "Corrige la réponse à une question chronométrée et calcule ses points selon le temps restant", Tag: "quiz",
+			Request: "AnswerRequest", Status: http.StatusOK, Response: "AnswerResult",
+		},
+		{
+			Method: http.MethodGet, Path: "/daily",
+			Handler: dailyChallengeHandler, OperationID: "getDailyChallenge", Summary: "Renvoie le défi du jour, avec les réponses si l'utilisateur l'a déjà tenté", Tag: "quiz",
+			Auth: true, Status: http.StatusOK, Response: "DailyChallenge",
+		},
+		{
+			Method: http.MethodPost, Path: "/daily/answer",
+			Handler: dailyAnswerHandler, OperationID: "answerDailyChallenge", Summary: "Enregistre l'unique tentative de l'utilisateur au défi du jour", Tag: "quiz",
+			Auth: true, Request: "DailyAnswer", Status: http.StatusCreated, Response: "DailyResult",
+		},
+		{
+			Method: http.MethodGet, Path: "/daily/leaderboard",
+			Handler: dailyLeaderboardHandler, OperationID: "getDailyLeaderboard", Summary: "Renvoie le classement du défi d'un jour", Tag: "leaderboard",
+			Params: []openAPIParam{
+				{Name: "date", In: "query", Type: "string", Description: "Jour du défi (2006-01-02), aujourd'hui par défaut"},
+				{Name: "limit", In: "query", Type: "integer", Description: "Nombre de joueurs renvoyés (1 à 100, 10 par défaut)"},
+			},
+			Status: http.StatusOK, Response: "DailyLeaderboard",
+		},
+		{
+			Method: http.MethodGet, Path: "/openapi.json",
+			Handler: openAPIHandler, OperationID: "getOpenAPI", Summary: "Renvoie cette spécification OpenAPI", Tag: "meta",
+			Status: http.StatusOK, Response: "Object",
+		},
+		{
+			Method: http.MethodGet, Path: "/metrics",
+			Handler: metricsHandler, OperationID: "getMetrics", Summary: "Renvoie les tentatives et échecs de chaque générateur de question", Tag: "meta",
+			Status: http.StatusOK, Response: "Object",
+		},
+	}
+}
+
+// monte les routes sous /api/v1 et conserve les anciens chemins comme alias dépréciés
+func registerRoutes(rt *router) {
+	for _, route := range apiRoutes() {
+		rt.handle(route.Method, apiPrefix+route.Path, route.Handler)
+		if route.LegacyPath != "" {
+			legacy := route.Handler
+			if route.LegacyHandler != nil {
+				legacy = route.LegacyHandler
+			}
+			rt.handle(route.Method, route.LegacyPath, deprecated(apiPrefix+route.Path, legacy))
+		}
+	}
+}
+
+// signale au client que le chemin est déprécié et indique son remplaçant
+func deprecated(successor string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		h(w, r)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// anciens noms de champs de users, écrits en minuscules quand User n'avait pas de tags bson
+var legacyUserFields = map[string]string{
+	"userid":       "userId",
+	"scoretotal":   "scoreTotal",
+	"nbdeparties":  "nbDeParties",
+	"scorehistory": "scoreHistory",
+	"userranking":  "userRanking",
+}
+
+// réécrit les documents de users avec les noms de champs du schéma ; les entrées de
+// userRanking, enregistrées avec "userid", sont aussi renommées. Sans effet si déjà migré.
+func migrateUserFields(ctx context.Context, db *mongo.Database) (int64, error) {
+	var hasLegacyField bson.A
+	set := bson.D{}
+	var unset bson.A
+	for legacy, current := range legacyUserFields {
+		hasLegacyField = append(hasLegacyField, bson.M{legacy: bson.M{"$exists": true}})
+		if legacy != "userranking" {
+			set = append(set, bson.E{Key: current, Value: bson.D{{Key: "$ifNull", Value: bson.A{"$" + current, "$" + legacy}}}})
+		}
+		unset = append(unset, legacy)
+	}
+	hasLegacyField = append(hasLegacyField, bson.M{"userRanking.userid": bson.M{"$exists": true}})
+
+	//userRanking écrit par l'ancien code sous "userRanking" mais avec des entrées "userid"
+	set = append(set, bson.E{Key: "userRanking", Value: bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$userRanking", bson.A{}}}}},
+		{Key: "as", Value: "entry"},
+		{Key: "in", Value: bson.D{
+			{Key: "userId", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$entry.userId", "$$entry.userid"}}}},
+			{Key: "pseudo", Value: "$$entry.pseudo"},
+			{Key: "scoreTotal", Value: "$$entry.scoreTotal"},
+			{Key: "rank", Value: "$$entry.rank"},
+		}},
+	}}}})
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: set}},
+		{{Key: "$unset", Value: unset}},
+	}
+	result, err := db.Collection("users").UpdateMany(ctx, bson.M{"$or": hasLegacyField}, update)
+	if err != nil {
+		return 0, fmt.Errorf("erreur lors de la migration des champs de users: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// complète les entrées du classement créées avant les critères de départage : nbDeParties
+// est repris de users et scoreAchievedAt, inconnu, vaut l'époque Unix pour que ces joueurs
+// restent devant ceux qui atteindront le même score plus tard. Sans effet si déjà migré.
+func backfillTieBreakers(ctx context.Context, db *mongo.Database) (int64, error) {
+	classement := db.Collection("classement")
+	missing := bson.M{"$or": bson.A{
+		bson.M{"nbDeParties": bson.M{"$exists": false}},
+		bson.M{"scoreAchievedAt": bson.M{"$exists": false}},
+	}}
+
+	cursor, err := classement.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: missing}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "users"},
+			{Key: "localField", Value: "userId"},
+			{Key: "foreignField", Value: "userId"},
+			{Key: "as", Value: "user"},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "userId", Value: 1},
+			{Key: "nbDeParties", Value: bson.D{{Key: "$ifNull", Value: bson.A{
+				"$nbDeParties", bson.D{{Key: "$first", Value: "$user.nbDeParties"}}, 0,
+			}}}},
+		}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("erreur lors de la lecture du classement à compléter: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var entries []struct {
+		UserID      string `bson:"userId"`
+		NbDeParties int    `bson:"nbDeParties"`
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return 0, fmt.Errorf("erreur lors de la lecture du classement à compléter: %w", err)
+	}
+
+	var migrated int64
+	for _, entry := range entries {
+		result, err := classement.UpdateOne(ctx, bson.M{"userId": entry.UserID}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "nbDeParties", Value: entry.NbDeParties},
+				{Key: "scoreAchievedAt", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$scoreAchievedAt", time.Unix(0, 0).UTC()}}}},
+			}}},
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("erreur lors de la mise à jour du classement de %s: %w", entry.UserID, err)
+		}
+		migrated += result.ModifiedCount
+	}
+	return migrated, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Groupes d'ex æquo. Le rang dense d'un joueur est le nombre de groupes d'ex æquo classés
+// devant lui, plus un ; pour l'obtenir par un seul comptage indexé, score_groups tient le nombre
+// de joueurs de chaque groupe, pour le classement de tous les temps et ceux des périodes, tous
+// pays confondus et par pays :
+//
+//	score_groups : { board, period, key, country, keys, scoreTotal, scoreAchievedAt, nbDeParties, count }
+//
+// keys liste les critères qui distinguent deux rangs (voir rankingKeys) : seuls ces champs sont
+// renseignés. Chaque écriture qui change le score, un critère de départage ou le pays d'un
+// joueur met ses groupes à jour dans la même transaction, et un groupe vidé est supprimé. Les
+// groupes sont reconstruits par migrateUp quand les critères configurés changent, ou par la
+// commande rebuild-score-groups.
+
+// version de la migration qui crée score_groups
+const scoreGroupsMigration = 15
+
+// identifiant, dans schema_migrations, de l'état des groupes : les critères de leur construction
+const scoreGroupsStateID = "scoreGroups"
+
+func scoreGroupsCollection(db *mongo.Database) *mongo.Collection {
+	return db.Collection("score_groups")
+}
+
+// critères de rang configurés, tels qu'enregistrés dans le champ keys des groupes
+func rankingKeysSignature() string {
+	keys := rankingKeys()
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = key.Field
+	}
+	return strings.Join(fields, ",")
+}
+
+// ajoute au filtre donné la restriction aux groupes du classement ; les champs absents de la
+// restriction (période, pays) valent null
+func (b board) groupFilter(filter bson.M) bson.M {
+	scoped := bson.M{
+		"board":   b.collection.Name(),
+		"period":  b.scope["period"],
+		"key":     b.scope["key"],
+		"country": b.scope["country"],
+		"keys":    rankingKeysSignature(),
+	}
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	return scoped
+}
+
+// filtre du groupe d'ex æquo du joueur dans le classement
+func (b board) playerGroup(player UserRanking) bson.M {
+	group := bson.M{}
+	for _, key := range rankingKeys() {
+		group[key.Field] = sortValue(player, key.Field)
+	}
+	return b.groupFilter(group)
+}
+
+// indique si deux états d'un joueur appartiennent au même groupe d'ex æquo
+func sameGroup(a, b UserRanking) bool {
+	entries := rankingEntries([]UserRanking{a, b})
+	return rankingConfig.Tied(entries[0], entries[1])
+}
+
+// ajoute delta joueurs au groupe du joueur dans le classement ; un groupe vidé est supprimé
+func addToScoreGroup(ctx context.Context, b board, player UserRanking, delta int) error {
+	groups := scoreGroupsCollection(b.collection.Database())
+	filter := b.playerGroup(player)
+	_, err := groups.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": delta}}, options.Update().SetUpsert(delta > 0))
+	if err == nil && delta < 0 {
+		filter["count"] = bson.M{"$lte": 0}
+		_, err = groups.DeleteOne(ctx, filter)
+	}
+	if err != nil {
+		return fmt.Errorf("erreur lors de la mise à jour des groupes de scores: %w", err)
+	}
+	return nil
+}
+
+// met à jour les groupes d'ex æquo quand un joueur du classement b passe de l'état before à
+// l'état after, tous pays confondus et dans le classement de son pays ; before est nil pour un
+// joueur qui entre dans le classement
+func updateScoreGroups(ctx context.Context, b board, before, after *UserRanking) error {
+	if before != nil && after != nil && before.Country == after.Country && sameGroup(*before, *after) {
+		return nil
+	}
+	for _, change := range []struct {
+		player *UserRanking
+		delta  int
+	}{{before, -1}, {after, 1}} {
+		if change.player == nil {
+			continue
+		}
+		boards := []board{b}
+		if change.player.Country != "" {
+			boards = append(boards, b.inCountry(change.player.Country))
+		}
+		for _, scoped := range boards {
+			if err := addToScoreGroup(ctx, scoped, *change.player, change.delta); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconstruit les groupes d'ex æquo de classement et period_scores pour les critères de rang
+// configurés ; les groupes sont calculés par MongoDB et insérés par lots
+func buildScoreGroups(ctx context.Context, db *mongo.Database) error {
+	groups := scoreGroupsCollection(db)
+	if _, err := groups.DeleteMany(ctx, bson.M{}); err != nil {
+		return fmt.Errorf("erreur lors du vidage des groupes de scores: %w", err)
+	}
+
+	signature := rankingKeysSignature()
+	for _, source := range []struct {
+		collection string
+		scope      []string
+	}{
+		{"classement", nil},
+		{"period_scores", []string{"period", "key"}},
+	} {
+		for _, byCountry := range []bool{false, true} {
+			id := bson.M{}
+			fields := source.scope
+			if byCountry {
+				fields = append(append([]string(nil), fields...), "country")
+			}
+			for _, field := range fields {
+				id[field] = "$" + field
+			}
+			for _, key := range rankingKeys() {
+				id[key.Field] = "$" + key.Field
+			}
+			pipeline := mongo.Pipeline{}
+			if byCountry {
+				pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"country": bson.M{"$nin": bson.A{nil, ""}}}}})
+			}
+			pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.M{"_id": id, "count": bson.M{"$sum": 1}}}})
+
+			cursor, err := db.Collection(source.collection).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+			if err != nil {
+				return fmt.Errorf("erreur lors du calcul des groupes de scores de %s: %w", source.collection, err)
+			}
+			if err := insertScoreGroups(ctx, groups, cursor, source.collection, signature); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insère par lots les groupes lus depuis le curseur d'agrégation
+func insertScoreGroups(ctx context.Context, groups *mongo.Collection, cursor *mongo.Cursor, collection, signature string) error {
+	defer cursor.Close(ctx)
+
+	const batchSize = 1000
+	batch := make([]interface{}, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := groups.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+			return fmt.Errorf("erreur lors de l'enregistrement des groupes de scores de %s: %w", collection, err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for cursor.Next(ctx) {
+		var group struct {
+			ID    bson.M `bson:"_id"`
+			Count int    `bson:"count"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return fmt.Errorf("erreur lors de la lecture des groupes de scores de %s: %w", collection, err)
+		}
+		doc := bson.M{"board": collection, "keys": signature, "count": group.Count}
+		for k, v := range group.ID {
+			doc[k] = v
+		}
+		batch = append(batch, doc)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("erreur lors de la lecture des groupes de scores de %s: %w", collection, err)
+	}
+	return flush()
+}
+
+// reconstruit les groupes d'ex æquo s'ils ont été construits pour d'autres critères de rang,
+// ou dans tous les cas si force est vrai ; l'appelant détient le verrou de migration
+func syncScoreGroups(ctx context.Context, client *mongo.Client, force bool) error {
+	signature := rankingKeysSignature()
+	var state struct {
+		Keys string `bson:"keys"`
+	}
+	err := migrationsCollection(client).FindOne(ctx, bson.M{"_id": scoreGroupsStateID}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("erreur lors de la lecture de l'état des groupes de scores: %w", err)
+	}
+	if err == nil && state.Keys == signature && !force {
+		return nil
+	}
+
+	slog.Info("reconstruction des groupes de scores", "keys", signature)
+	if err := buildScoreGroups(ctx, quizzerDB(client)); err != nil {
+		return err
+	}
+	_, err = migrationsCollection(client).UpdateOne(ctx,
+		bson.M{"_id": scoreGroupsStateID},
+		bson.M{"$set": bson.M{"keys": signature}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement de l'état des groupes de scores: %w", err)
+	}
+	return nil
+}
+
+// reconstruit les groupes d'ex æquo, par exemple après des écritures faites par une instance
+// antérieure à leur création
+func rebuildScoreGroups(ctx context.Context) error {
+	client, err := connectToMongo()
+	if err != nil {
+		return fmt.Errorf("erreur lors de la connexion à MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	unlock, err := acquireMigrationLock(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return syncScoreGroups(ctx, client, true)
+}
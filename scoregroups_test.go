@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"serveur/ranking"
+)
+
+func TestSameGroup(t *testing.T) {
+	player := UserRanking{Score: 50, NbDeParties: 3, ScoreAchievedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name        string
+		tieBreakers []ranking.TieBreaker
+		other       UserRanking
+		want        bool
+	}{
+		{name: "même score", other: UserRanking{Score: 50, NbDeParties: 9}, want: true},
+		{name: "autre score", other: UserRanking{Score: 40, NbDeParties: 3}, want: false},
+		{name: "départage par les parties", tieBreakers: []ranking.TieBreaker{ranking.FewerGames}, other: UserRanking{Score: 50, NbDeParties: 4}, want: false},
+		{
+			name:        "même instant dans un autre fuseau",
+			tieBreakers: []ranking.TieBreaker{ranking.EarlierScore},
+			other:       UserRanking{Score: 50, ScoreAchievedAt: player.ScoreAchievedAt.In(time.FixedZone("UTC+1", 3600))},
+			want:        true,
+		},
+	}
+
+	configured := rankingConfig
+	defer func() { rankingConfig = configured }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rankingConfig = ranking.Config{Method: ranking.Dense, TieBreakers: tt.tieBreakers}
+			if got := sameGroup(player, tt.other); got != tt.want {
+				t.Errorf("sameGroup = %v, attendu %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// le rang dense lu dans score_groups est celui calculé sur le classement complet, y compris
+// après le déplacement d'un joueur vers un autre score et un autre pays
+func TestDenseRankFromScoreGroups(t *testing.T) {
+	configured := rankingConfig
+	defer func() { rankingConfig = configured }()
+	rankingConfig = ranking.Config{Method: ranking.Dense}
+
+	client := transactionalTestClient(t)
+	ctx := context.Background()
+	db := quizzerDB(client)
+
+	rng := rand.New(rand.NewSource(3))
+	var players []UserRanking
+	for i := 0; i < 200; i++ {
+		player := UserRanking{UserID: fmt.Sprintf("u%03d", i), Pseudo: fmt.Sprintf("joueur%d", i), Score: rng.Intn(20) * 10}
+		if i%3 == 0 {
+			player.Country = "France"
+		}
+		players = append(players, player)
+		if _, err := db.Collection("classement").InsertOne(ctx, player); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := buildScoreGroups(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string) {
+		t.Helper()
+		for _, country := range []string{"", "France"} {
+			var scoped []UserRanking
+			for _, player := range players {
+				if country == "" || player.Country == country {
+					scoped = append(scoped, player)
+				}
+			}
+			want := make(map[string]int)
+			entries := rankingEntries(scoped)
+			for i, rank := range rankingConfig.Rank(entries) {
+				want[entries[i].ID] = rank
+			}
+			for _, player := range scoped {
+				start, err := rankStart(ctx, allTimeBoard(db).inCountry(country), player)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if start.Rank != want[player.UserID] {
+					t.Fatalf("%s, pays %q : rang de %s = %d, attendu %d", step, country, player.UserID, start.Rank, want[player.UserID])
+				}
+			}
+		}
+	}
+
+	check("après construction")
+
+	//seul joueur à 1000 points, hors de France puis en France
+	before := players[1]
+	after := before
+	after.Score, after.Country = 1000, "France"
+	if err := updateScoreGroups(ctx, allTimeBoard(db), &before, &after); err != nil {
+		t.Fatal(err)
+	}
+	players[1] = after
+	check("après déplacement")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Questions récemment vues. Chaque question chronométrée servie à un joueur connecté est
+// enregistrée avec son empreinte (type + entités : artistes, pistes) pendant la durée de
+// QUESTION_COOLDOWN :
+//
+//	seen_questions : { _id, userId, type, fingerprint, entities, seenAt, expireAt }
+//
+// À la génération suivante, les générateurs évitent les entités vues (sampleArtists, tendances
+// régionales) et une question dont l'empreinte a déjà été vue est régénérée. Les questions
+// demandées avec ?seed= restent reproductibles : elles ne sont ni filtrées ni enregistrées.
+
+// durée par défaut pendant laquelle une question vue n'est plus reposée au joueur
+const defaultQuestionCooldown = 24 * time.Hour
+
+// durée lue au démarrage par loadQuestionCooldown ; 0 désactive le suivi
+var questionCooldown = defaultQuestionCooldown
+
+// lit QUESTION_COOLDOWN, une durée (« 12h ») ou 0 pour désactiver le suivi ; garde la durée par
+// défaut si la valeur est invalide
+func loadQuestionCooldown() {
+	raw := strings.TrimSpace(os.Getenv("QUESTION_COOLDOWN"))
+	if raw == "" {
+		return
+	}
+	if raw == "0" {
+		questionCooldown = 0
+		return
+	}
+	cooldown, err := time.ParseDuration(raw)
+	if err != nil || cooldown < 0 {
+		slog.Error("configuration du délai avant de reposer une question ignorée", "error", fmt.Errorf("durée invalide: %q", raw))
+		return
+	}
+	questionCooldown = cooldown
+}
+
+// identifiants des entités d'une question, utilisés dans QuestionTrend.Entities
+func artistEntity(id string) string  { return "artist:" + id }
+func trackEntity(name string) string { return "track:" + name }
+
+// empreinte d'une question : son type et ses entités, quel que soit leur ordre
+func questionFingerprint(question QuestionTrend) string {
+	entities := append([]string(nil), question.Entities...)
+	sort.Strings(entities)
+	h := fnv.New64a()
+	h.Write([]byte(question.Type))
+	for _, entity := range entities {
+		h.Write([]byte{0})
+		h.Write([]byte(entity))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// questions vues récemment par un joueur
+type recentQuestions struct {
+	entities     map[string]bool
+	fingerprints map[string]bool
+}
+
+// indique si l'entité a été vue récemment ; sûr sur un recentQuestions nil
+func (recent *recentQuestions) seenEntity(entity string) bool {
+	return recent != nil && recent.entities[entity]
+}
+
+// indique si la question a déjà été posée récemment ; sûr sur un recentQuestions nil
+func (recent *recentQuestions) seenQuestion(question QuestionTrend) bool {
+	return recent != nil && len(question.Entities) > 0 && recent.fingerprints[questionFingerprint(question)]
+}
+
+// lit les questions vues par le joueur pendant le délai configuré
+func loadRecentQuestions(ctx context.Context, db *mongo.Database, userID string, now time.Time) (*recentQuestions, error) {
+	recent := &recentQuestions{entities: make(map[string]bool), fingerprints: make(map[string]bool)}
+	if userID == "" || questionCooldown <= 0 {
+		return recent, nil
+	}
+	cursor, err := db.Collection("seen_questions").Find(ctx,
+		bson.M{"userId": userID, "seenAt": bson.M{"$gt": now.UTC().Add(-questionCooldown)}},
+		options.Find().SetProjection(bson.M{"fingerprint": 1, "entities": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des questions récentes: %w", err)
+	}
+	var seen []struct {
+		Fingerprint string   `bson:"fingerprint"`
+		Entities    []string `bson:"entities"`
+	}
+	if err = cursor.All(ctx, &seen); err != nil {
+		return nil, fmt.Errorf("erreur lors de la lecture des questions récentes: %w", err)
+	}
+	for _, question := range seen {
+		recent.fingerprints[question.Fingerprint] = true
+		for _, entity := range question.Entities {
+			recent.entities[entity] = true
+		}
+	}
+	return recent, nil
+}
+
+// enregistre la question comme vue par le joueur ; la revoir repousse la fin du délai
+func markQuestionSeen(ctx context.Context, db *mongo.Database, userID string, question QuestionTrend, now time.Time) error {
+	if userID == "" || questionCooldown <= 0 || len(question.Entities) == 0 {
+		return nil
+	}
+	fingerprint := questionFingerprint(question)
+	_, err := db.Collection("seen_questions").UpdateOne(ctx,
+		bson.M{"userId": userID, "fingerprint": fingerprint},
+		bson.M{"$set": bson.M{
+			"type":     question.Type,
+			"entities": question.Entities,
+			"seenAt":   now.UTC(),
+			"expireAt": now.UTC().Add(questionCooldown),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement de la question vue: %w", err)
+	}
+	return nil
+}
+
+// index de seen_questions : une entrée par joueur et empreinte, supprimée à la fin du délai
+var seenQuestionsIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "fingerprint", Value: 1}},
+		Options: options.Index().SetName("userId_1_fingerprint_1_unique").SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetName("expireAt_1_ttl").SetExpireAfterSeconds(0),
+	},
+}
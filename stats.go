@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 365
+	// nombre minimal de réponses pour qu'un genre soit classé parmi les préférés ou les plus faibles
+	minGenreAnswers = 3
+	// nombre de genres renvoyés dans chacune des deux listes
+	statsGenres = 3
+)
+
+// taux de bonnes réponses pour un type de question ou un genre
+type accuracy struct {
+	Key      string  `bson:"_id" json:"key"`
+	Answers  int     `bson:"answers" json:"answers"`
+	Correct  int     `bson:"correct" json:"correct"`
+	Accuracy float64 `bson:"-" json:"accuracy"`
+}
+
+// nombre de parties d'une journée (UTC)
+type dailyGames struct {
+	Date  string `bson:"_id" json:"date"`
+	Games int    `bson:"games" json:"games"`
+}
+
+// rang au classement de tous les temps après une partie
+type rankPoint struct {
+	PlayedAt time.Time `bson:"playedAt" json:"playedAt"`
+	Rank     int       `bson:"rankAfter" json:"rank"`
+}
+
+// statistiques du joueur, calculées à partir de quiz_results
+type playerStats struct {
+	Days              int          `json:"days"`
+	Games             int          `json:"games"`
+	AnsweredQuestions int          `json:"answeredQuestions"`
+	AverageScore      float64      `json:"averageScore"`
+	BestScore         int          `json:"bestScore"`
+	AverageDurationMs float64      `json:"averageDurationMs"`
+	AccuracyByType    []accuracy   `json:"accuracyByType"`
+	CurrentStreak     int          `json:"currentStreakDays"`
+	LongestStreak     int          `json:"longestStreakDays"`
+	GamesPerDay       []dailyGames `json:"gamesPerDay"`
+	RankHistory       []rankPoint  `json:"rankHistory"`
+	FavouriteGenres   []accuracy   `json:"favouriteGenres"`
+	WeakestGenres     []accuracy   `json:"weakestGenres"`
+}
+
+// étapes d'agrégation qui comptent les réponses, bonnes et totales, par valeur de field
+func accuracyStages(field string) []bson.D {
+	return []bson.D{
+		{{Key: "$unwind", Value: "$answers"}},
+		{{Key: "$match", Value: bson.M{"answers." + field: bson.M{"$exists": true, "$ne": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$answers." + field,
+			"answers": bson.M{"$sum": 1},
+			"correct": bson.M{"$sum": bson.M{"$cond": bson.A{"$answers.correct", 1, 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+}
+
+// calcule le taux de bonnes réponses de chaque entrée
+func withAccuracy(entries []accuracy) []accuracy {
+	for i := range entries {
+		if entries[i].Answers > 0 {
+			entries[i].Accuracy = float64(entries[i].Correct) / float64(entries[i].Answers)
+		}
+	}
+	return entries
+}
+
+// sépare les genres assez joués en préférés (meilleur taux) et plus faibles (moins bon taux)
+func rankGenres(genres []accuracy) (favourite, weakest []accuracy) {
+	var eligible []accuracy
+	for _, genre := range genres {
+		if genre.Answers >= minGenreAnswers {
+			eligible = append(eligible, genre)
+		}
+	}
+	sort.SliceStable(eligible, func(i, j int) bool {
+		if eligible[i].Accuracy != eligible[j].Accuracy {
+			return eligible[i].Accuracy > eligible[j].Accuracy
+		}
+		return eligible[i].Answers > eligible[j].Answers
+	})
+
+	favourite, weakest = []accuracy{}, []accuracy{}
+	for i := 0; i < len(eligible) && i < statsGenres; i++ {
+		favourite = append(favourite, eligible[i])
+	}
+	//un genre ne figure pas dans les deux listes
+	for i := len(eligible) - 1; i >= len(favourite) && len(weakest) < statsGenres; i-- {
+		weakest = append(weakest, eligible[i])
+	}
+	return favourite, weakest
+}
+
+// calcule la série en cours (jours consécutifs jusqu'à aujourd'hui ou hier) et la plus
+// longue série, à partir des jours joués triés au format 2006-01-02
+func dayStreaks(days []string, today time.Time) (current, longest int) {
+	var previous time.Time
+	run := 0
+	for _, day := range days {
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			continue
+		}
+		if run > 0 && date.Equal(previous.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+		previous = date
+	}
+
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if run > 0 && (previous.Equal(today) || previous.Equal(today.AddDate(0, 0, -1))) {
+		current = run
+	}
+	return current, longest
+}
+
+// calcule les statistiques du joueur ; gamesPerDay et rankHistory couvrent les days derniers jours
+func computePlayerStats(ctx context.Context, db *mongo.Database, userID string, days int, now time.Time) (playerStats, error) {
+	now = now.UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -(days - 1))
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$facet", Value: bson.M{
+			"summary": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":      nil,
+					"games":    bson.M{"$sum": 1},
+					"average":  bson.M{"$avg": "$score"},
+					"best":     bson.M{"$max": "$score"},
+					"duration": bson.M{"$avg": "$durationMs"},
+					"answered": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": bson.A{"$answers", bson.A{}}}}},
+				}},
+			},
+			"byType":  accuracyStages("type"),
+			"byGenre": accuracyStages("genre"),
+			"days": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$playedAt"}},
+					"games": bson.M{"$sum": 1},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"ranks": bson.A{
+				bson.M{"$match": bson.M{"playedAt": bson.M{"$gte": since}, "rankAfter": bson.M{"$gt": 0}}},
+				bson.M{"$sort": bson.M{"playedAt": 1}},
+				bson.M{"$project": bson.M{"_id": 0, "playedAt": 1, "rankAfter": 1}},
+			},
+		}}},
+	}
+
+	cursor, err := db.Collection("quiz_results").Aggregate(ctx, pipeline)
+	if err != nil {
+		return playerStats{}, fmt.Errorf("erreur lors du calcul des statistiques: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var facets []struct {
+		Summary []struct {
+			Games    int     `bson:"games"`
+			Average  float64 `bson:"average"`
+			Best     int     `bson:"best"`
+			Duration float64 `bson:"duration"`
+			Answered int     `bson:"answered"`
+		} `bson:"summary"`
+		ByType  []accuracy   `bson:"byType"`
+		ByGenre []accuracy   `bson:"byGenre"`
+		Days    []dailyGames `bson:"days"`
+		Ranks   []rankPoint  `bson:"ranks"`
+	}
+	if err = cursor.All(ctx, &facets); err != nil {
+		return playerStats{}, fmt.Errorf("erreur lors de la lecture des statistiques: %w", err)
+	}
+
+	stats := playerStats{
+		Days:           days,
+		AccuracyByType: []accuracy{},
+		GamesPerDay:    []dailyGames{},
+		RankHistory:    []rankPoint{},
+	}
+	if len(facets) == 0 {
+		stats.FavouriteGenres, stats.WeakestGenres = []accuracy{}, []accuracy{}
+		return stats, nil
+	}
+	facet := facets[0]
+	if len(facet.Summary) > 0 {
+		summary := facet.Summary[0]
+		stats.Games = summary.Games
+		stats.AverageScore = summary.Average
+		stats.BestScore = summary.Best
+		stats.AverageDurationMs = summary.Duration
+		stats.AnsweredQuestions = summary.Answered
+	}
+	if facet.ByType != nil {
+		stats.AccuracyByType = withAccuracy(facet.ByType)
+	}
+	stats.FavouriteGenres, stats.WeakestGenres = rankGenres(withAccuracy(facet.ByGenre))
+	if facet.Ranks != nil {
+		stats.RankHistory = facet.Ranks
+	}
+
+	playedDays := make([]string, 0, len(facet.Days))
+	sinceKey := since.Format("2006-01-02")
+	for _, day := range facet.Days {
+		playedDays = append(playedDays, day.Date)
+		if day.Date >= sinceKey {
+			stats.GamesPerDay = append(stats.GamesPerDay, day)
+		}
+	}
+	stats.CurrentStreak, stats.LongestStreak = dayStreaks(playedDays, now)
+	return stats, nil
+}
+
+// --------------- Handler gérant les statistiques ---------------------
+
+// handler qui renvoie les statistiques de l'utilisateur connecté
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	days, ferr := intQueryParam(r, "days", defaultStatsDays, 1, maxStatsDays)
+	if ferr != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", []fieldError{*ferr})
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	stats, err := computePlayerStats(r.Context(), quizzerDB(client), userID, days, time.Now())
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors du calcul des statistiques", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors du calcul des statistiques", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
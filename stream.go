@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"serveur/ranking"
+)
+
+const (
+	defaultStreamTop = 5
+	maxStreamTop     = 50
+	// intervalle des commentaires envoyés pour garder la connexion ouverte derrière un proxy
+	streamKeepAlive = 25 * time.Second
+	// délai minimal entre deux recalculs du classement pour un même abonné
+	streamMinInterval = 500 * time.Millisecond
+)
+
+// Diffusion en temps réel du classement. Chaque score enregistré est publié sur scoreChanges ;
+// les connexions GET /leaderboard/stream abonnées recalculent alors leur top N et le rang de
+// l'utilisateur connecté, et n'envoient un événement que si le résultat a changé.
+//
+// Par défaut seuls les quiz terminés sur cette instance sont publiés. Avec
+// LEADERBOARD_CHANGE_STREAM=on (replica set requis), les modifications de la collection
+// classement sont aussi suivies par un change stream, ce qui couvre les autres instances.
+
+// prévient tous les abonnés qu'un score a changé
+type scoreBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+var scoreChanges = &scoreBroker{subscribers: make(map[chan struct{}]struct{})}
+
+// abonne un client ; le canal, de capacité 1, regroupe les changements reçus pendant que
+// le client recalcule son classement. La fonction renvoyée met fin à l'abonnement.
+func (b *scoreBroker) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// prévient les abonnés sans jamais bloquer l'appelant
+func (b *scoreBroker) publish(userID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	slog.Debug("changement de score publié", "user_id", userID, "subscribers", len(b.subscribers))
+}
+
+// suit les modifications de la collection classement et les publie, jusqu'à l'annulation du
+// contexte ; le suivi reprend après une erreur, à partir du dernier événement reçu
+func watchClassement(ctx context.Context) {
+	var resumeToken bson.Raw
+	for ctx.Err() == nil {
+		err := func() error {
+			client, err := connectToMongo()
+			if err != nil {
+				return err
+			}
+			defer client.Disconnect(context.Background())
+
+			opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+			if resumeToken != nil {
+				opts.SetResumeAfter(resumeToken)
+			}
+			pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+				"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+			}}}}
+			stream, err := quizzerDB(client).Collection("classement").Watch(ctx, pipeline, opts)
+			if err != nil {
+				return err
+			}
+			defer stream.Close(context.Background())
+
+			for stream.Next(ctx) {
+				var event struct {
+					FullDocument UserRanking `bson:"fullDocument"`
+				}
+				if err := stream.Decode(&event); err != nil {
+					return err
+				}
+				resumeToken = stream.ResumeToken()
+				scoreChanges.publish(event.FullDocument.UserID)
+			}
+			return stream.Err()
+		}()
+		if err != nil && ctx.Err() == nil {
+			slog.Error("erreur lors du suivi des modifications du classement", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// démarre le change stream si LEADERBOARD_CHANGE_STREAM=on
+func startChangeStream(ctx context.Context) {
+	if strings.EqualFold(os.Getenv("LEADERBOARD_CHANGE_STREAM"), "on") {
+		slog.Info("suivi du classement par change stream activé")
+		go watchClassement(ctx)
+	}
+}
+
+// rang de l'utilisateur abonné ; Player est null s'il n'est pas dans le classement demandé
+type streamRank struct {
+	UserID string       `json:"userId"`
+	Player *UserRanking `json:"player"`
+}
+
+// écrit un événement SSE et l'envoie immédiatement au client
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, id int, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// --------------- Handler gérant le classement en temps réel ---------------------
+
+// handler qui envoie, en Server-Sent Events, le top N du classement à chaque changement
+// (événement « top ») et, pour un utilisateur connecté, son rang à chaque changement
+// (événement « rank ») ; le token peut être passé dans ?token= car EventSource ne permet
+// pas d'ajouter d'en-tête
+func leaderboardStreamHandler(w http.ResponseWriter, r *http.Request) {
+	var errs []fieldError
+	top, ferr := intQueryParam(r, "top", defaultStreamTop, 1, maxStreamTop)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	p, ferr := periodFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	country, ferr := countryFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
+		return
+	}
+
+	//l'utilisateur est facultatif : sans token, seul le top N est envoyé
+	var userID string
+	if r.Header.Get("Authorization") != "" {
+		var ok bool
+		if userID, ok = userIDFromRequest(w, r); !ok {
+			return
+		}
+	} else if token := r.URL.Query().Get("token"); token != "" {
+		var ok bool
+		if userID, ok = userIDFromToken(token); !ok {
+			writeError(w, http.StatusUnauthorized, codeInvalidToken, "Token d'autorisation invalide", nil)
+			return
+		}
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	//abonnement avant la première lecture pour ne manquer aucun changement
+	changes, unsubscribe := scoreChanges.subscribe()
+	defer unsubscribe()
+
+	ctx := r.Context()
+	db := quizzerDB(client)
+	snapshot := func() (topPlayers, rank []byte, err error) {
+		//la période en cours est résolue à chaque fois pour suivre le changement de jour ou de saison
+		resolved, err := resolvePeriod(ctx, db, p, time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		b := periodBoard(db, resolved).inCountry(country)
+
+		players, err := findPlayers(ctx, b, bson.M{}, options.Find().SetSort(rankingSort(orderKeys(), false)).SetLimit(int64(top)))
+		if err == nil {
+			err = assignRanks(ctx, b, players)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		topPlayers, _ = json.Marshal(struct {
+			period
+			Country string        `json:"country,omitempty"`
+			Players []UserRanking `json:"players"`
+		}{resolved, country, players})
+
+		if userID == "" {
+			return topPlayers, nil, nil
+		}
+		current := streamRank{UserID: userID}
+		var player UserRanking
+		err = b.collection.FindOne(ctx, b.filter(bson.M{"userId": userID})).Decode(&player)
+		if err == nil {
+			var start ranking.Start
+			start, err = rankStart(ctx, b, player)
+			player.Rank = start.Rank
+			current.Player = &player
+		} else if err == mongo.ErrNoDocuments {
+			err = nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rank, _ = json.Marshal(current)
+		return topPlayers, rank, nil
+	}
+
+	lastTop, lastRank, err := snapshot()
+	if err != nil {
+		loggerFrom(ctx).Error("erreur lors de la récupération du classement", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement", nil)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	eventID := 1
+	if err := writeEvent(w, rc, eventID, "top", lastTop); err != nil {
+		return
+	}
+	if lastRank != nil {
+		eventID++
+		if err := writeEvent(w, rc, eventID, "rank", lastRank); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	var lastComputed time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-changes:
+			//limite la fréquence des recalculs lorsque beaucoup de quiz se terminent en même temps
+			if wait := streamMinInterval - time.Since(lastComputed); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+			lastComputed = time.Now()
+
+			topPlayers, rank, err := snapshot()
+			if err != nil {
+				loggerFrom(ctx).Error("erreur lors du recalcul du classement diffusé", "error", err)
+				continue
+			}
+			if !bytes.Equal(topPlayers, lastTop) {
+				eventID++
+				if err := writeEvent(w, rc, eventID, "top", topPlayers); err != nil {
+					return
+				}
+				lastTop = topPlayers
+			}
+			if rank != nil && !bytes.Equal(rank, lastRank) {
+				eventID++
+				if err := writeEvent(w, rc, eventID, "rank", rank); err != nil {
+					return
+				}
+				lastRank = rank
+			}
+		}
+	}
+}
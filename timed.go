@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Questions chronométrées. Chaque question servie par GET /api/v1/questions/random est
+// enregistrée dans issued_questions avec l'heure d'émission et sa date limite, et sa réponse
+// n'est pas envoyée au client :
+//
+//	issued_questions : { _id, userId, type, genre, difficulty, question, choices, answer, seed, practice,
+//	                     issuedAt, deadline, answeredAt, givenAnswer, correct, points, countedAt }
+//
+// Le joueur répond par POST /api/v1/questions/answers ; les points dépendent de la bonne
+// réponse et du temps restant. En fin de quiz, POST /api/v1/me/quiz-results avec questionIds
+// additionne ces points côté serveur au lieu du score envoyé par le client.
+//
+// Une question demandée avec ?seed= est reproductible (l'ancien chemin renvoie la réponse), elle
+// est donc corrigée mais ne rapporte aucun point.
+
+const (
+	maxQuestionPoints = 100
+	// points d'une bonne réponse donnée à la dernière seconde
+	minQuestionPoints = 50
+	// tolérance après la date limite pour la latence réseau
+	deadlineGrace = time.Second
+	// durée de conservation des questions servies
+	issuedQuestionRetention = 7 * 24 * time.Hour
+	maxAnswerBodyBytes      = 1 << 10
+)
+
+// temps de réponse par difficulté, et par type de question lorsqu'il est précisé ; lus au
+// démarrage par loadQuestionTimeLimits
+var (
+	difficultyTimeLimits = map[int]time.Duration{
+		difficultyEasy:   15 * time.Second,
+		difficultyMedium: 20 * time.Second,
+		difficultyHard:   30 * time.Second,
+	}
+	typeTimeLimits = map[string]time.Duration{}
+)
+
+// noms des difficultés dans QUESTION_TIME_LIMITS
+var difficultyNames = map[string]int{
+	"easy":   difficultyEasy,
+	"medium": difficultyMedium,
+	"hard":   difficultyHard,
+}
+
+// lit QUESTION_TIME_LIMITS, liste de clé=durée séparées par des virgules où la clé est une
+// difficulté (easy, medium, hard) ou un type de question (« hard=40s,genre=25s ») ; le temps
+// d'un type l'emporte sur celui de sa difficulté. Garde les temps par défaut si la configuration
+// est invalide.
+func loadQuestionTimeLimits() {
+	raw := strings.TrimSpace(os.Getenv("QUESTION_TIME_LIMITS"))
+	if raw == "" {
+		return
+	}
+	byDifficulty := make(map[int]time.Duration)
+	byType := make(map[string]time.Duration)
+	for _, part := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		key = strings.TrimSpace(key)
+		limit, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || err != nil || limit <= 0 {
+			slog.Error("configuration des temps de réponse ignorée", "error", fmt.Errorf("temps invalide: %q", part))
+			return
+		}
+		if difficulty, ok := difficultyNames[key]; ok {
+			byDifficulty[difficulty] = limit
+		} else if _, ok := questionGenerators[key]; ok {
+			byType[key] = limit
+		} else {
+			slog.Error("configuration des temps de réponse ignorée", "error", fmt.Errorf("difficulté ou type de question inconnu: %q", key))
+			return
+		}
+	}
+	for difficulty, limit := range byDifficulty {
+		difficultyTimeLimits[difficulty] = limit
+	}
+	for questionType, limit := range byType {
+		typeTimeLimits[questionType] = limit
+	}
+}
+
+// temps de réponse accordé pour une question
+func questionTimeLimit(questionType string, difficulty int) time.Duration {
+	if limit, ok := typeTimeLimits[questionType]; ok {
+		return limit
+	}
+	if limit, ok := difficultyTimeLimits[difficulty]; ok {
+		return limit
+	}
+	return difficultyTimeLimits[difficultyMedium]
+}
+
+// points d'une réponse : rien si elle est fausse, sinon de minQuestionPoints à maxQuestionPoints
+// selon la part du temps restant, multipliés selon la difficulté
+func questionPoints(correct bool, elapsed, limit time.Duration, difficulty int) int {
+	if !correct || limit <= 0 {
+		return 0
+	}
+	remaining := limit - elapsed
+	if remaining < 0 {
+		remaining = 0
+	} else if remaining > limit {
+		remaining = limit
+	}
+	bonus := float64(maxQuestionPoints-minQuestionPoints) * float64(remaining) / float64(limit)
+	return scaledPoints(minQuestionPoints+int(math.Round(bonus)), difficulty)
+}
+
+// question servie à un joueur
+type issuedQuestion struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      string             `bson:"userId,omitempty"`
+	Type        string             `bson:"type"`
+	Genre       string             `bson:"genre,omitempty"`
+	Difficulty  int                `bson:"difficulty"`
+	Question    string             `bson:"question"`
+	Choices     []string           `bson:"choices"`
+	Answer      string             `bson:"answer"`
+	Seed        int64              `bson:"seed"`
+	Practice    bool               `bson:"practice,omitempty"`
+	IssuedAt    time.Time          `bson:"issuedAt"`
+	Deadline    time.Time          `bson:"deadline"`
+	AnsweredAt  *time.Time         `bson:"answeredAt,omitempty"`
+	GivenAnswer string             `bson:"givenAnswer,omitempty"`
+	Correct     bool               `bson:"correct"`
+	Points      int                `bson:"points"`
+	CountedAt   *time.Time         `bson:"countedAt,omitempty"`
+}
+
+// question chronométrée telle qu'envoyée au joueur, sans la réponse ; la graine n'est renvoyée
+// que si le client l'a choisie
+type timedQuestion struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Difficulty  int       `json:"difficulty"`
+	Question    string    `json:"question"`
+	Choices     []string  `json:"choices"`
+	Seed        *int64    `json:"seed,omitempty"`
+	IssuedAt    time.Time `json:"issuedAt"`
+	Deadline    time.Time `json:"deadline"`
+	TimeLimitMs int64     `json:"timeLimitMs"`
+}
+
+// enregistre la question servie et renvoie sa version sans réponse
+func issueQuestion(ctx context.Context, db *mongo.Database, userID string, question QuestionTrend, practice bool, now time.Time) (timedQuestion, error) {
+	limit := questionTimeLimit(question.Type, question.Difficulty)
+	issued := issuedQuestion{
+		UserID:     userID,
+		Type:       question.Type,
+		Genre:      question.Genre,
+		Difficulty: question.Difficulty,
+		Question:   question.Question,
+		Choices:    question.Choices,
+		Answer:     question.Answer,
+		Seed:       question.Seed,
+		Practice:   practice,
+		IssuedAt:   now.UTC(),
+		Deadline:   now.UTC().Add(limit),
+	}
+	result, err := db.Collection("issued_questions").InsertOne(ctx, issued)
+	if err != nil {
+		return timedQuestion{}, fmt.Errorf("erreur lors de l'enregistrement de la question: %w", err)
+	}
+	id, _ := result.InsertedID.(primitive.ObjectID)
+
+	timed := timedQuestion{
+		ID:          id.Hex(),
+		Type:        issued.Type,
+		Difficulty:  issued.Difficulty,
+		Question:    issued.Question,
+		Choices:     issued.Choices,
+		IssuedAt:    issued.IssuedAt,
+		Deadline:    issued.Deadline,
+		TimeLimitMs: limit.Milliseconds(),
+	}
+	if practice {
+		timed.Seed = &issued.Seed
+	}
+	return timed, nil
+}
+
+// comptabilise, pour le quiz qui se termine, les questions servies à l'utilisateur ; une question
+// déjà comptée, inconnue ou servie à un autre joueur est ignorée, une question sans réponse compte
+// comme fausse
+func claimIssuedQuestions(ctx context.Context, db *mongo.Database, userID string, ids []primitive.ObjectID, now time.Time) (int, []questionOutcome, error) {
+	score := 0
+	outcomes := make([]questionOutcome, 0, len(ids))
+	for _, id := range ids {
+		var question issuedQuestion
+		err := db.Collection("issued_questions").FindOneAndUpdate(ctx,
+			bson.M{"_id": id, "userId": userID, "countedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"countedAt": now.UTC()}},
+		).Decode(&question)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("erreur lors du décompte de la question %s: %w", id.Hex(), err)
+		}
+		score += question.Points
+		outcomes = append(outcomes, questionOutcome{Type: question.Type, Genre: question.Genre, Correct: question.Correct})
+	}
+	return score, outcomes, nil
+}
+
+// identifiant de l'utilisateur si la requête porte un token ; sans en-tête Authorization la
+// requête est anonyme, un token invalide est refusé
+func optionalUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return "", true
+	}
+	return userIDFromRequest(w, r)
+}
+
+// corps de la réponse à une question chronométrée
+type answerRequest struct {
+	QuestionID string `json:"questionId"`
+	Answer     string `json:"answer"`
+}
+
+// correction d'une question chronométrée
+type answerResult struct {
+	QuestionID  string `json:"questionId"`
+	Correct     bool   `json:"correct"`
+	Answer      string `json:"answer"`
+	Points      int    `json:"points"`
+	ElapsedMs   int64  `json:"elapsedMs"`
+	TimeLimitMs int64  `json:"timeLimitMs"`
+}
+
+// --------------- Handler gérant les réponses aux questions ---------------------
+
+// handler qui corrige la réponse à une question servie et calcule ses points ; une question
+// servie à un utilisateur connecté ne peut recevoir de réponse que de sa part
+func answerQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	userID, ok := optionalUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req answerRequest
+	if !decodeJSONBody(w, r, &req, maxAnswerBodyBytes) {
+		return
+	}
+	var errs []fieldError
+	id, err := primitive.ObjectIDFromHex(req.QuestionID)
+	if err != nil {
+		errs = append(errs, fieldError{"questionId", "invalid", "Identifiant de question invalide"})
+	}
+	if req.Answer == "" {
+		errs = append(errs, fieldError{"answer", "required", "La réponse est requise"})
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "La réponse est invalide", errs)
+		return
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	questions := quizzerDB(client).Collection("issued_questions")
+	var question issuedQuestion
+	err = questions.FindOne(r.Context(), bson.M{"_id": id}).Decode(&question)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && question.UserID != "" && question.UserID != userID) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Question introuvable", nil)
+		return
+	} else if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération de la question", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération de la question", nil)
+		return
+	}
+	if question.AnsweredAt != nil {
+		writeError(w, http.StatusConflict, codeAlreadyAnswered, "La question a déjà reçu une réponse", nil)
+		return
+	}
+	if now.After(question.Deadline.Add(deadlineGrace)) {
+		writeError(w, http.StatusGone, codeQuestionExpired, "Le temps de réponse est écoulé", map[string]time.Time{"deadline": question.Deadline})
+		return
+	}
+
+	limit := question.Deadline.Sub(question.IssuedAt)
+	elapsed := now.Sub(question.IssuedAt)
+	correct := req.Answer == question.Answer
+	points := 0
+	if !question.Practice {
+		points = questionPoints(correct, elapsed, limit, question.Difficulty)
+	}
+
+	//la condition sur answeredAt garantit une seule réponse, même en cas de requêtes simultanées
+	result, err := questions.UpdateOne(r.Context(),
+		bson.M{"_id": id, "answeredAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"answeredAt": now, "givenAnswer": req.Answer, "correct": correct, "points": points}},
+	)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de l'enregistrement de la réponse", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de l'enregistrement de la réponse", nil)
+		return
+	}
+	if result.MatchedCount == 0 {
+		writeError(w, http.StatusConflict, codeAlreadyAnswered, "La question a déjà reçu une réponse", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, answerResult{
+		QuestionID:  req.QuestionID,
+		Correct:     correct,
+		Answer:      question.Answer,
+		Points:      points,
+		ElapsedMs:   elapsed.Milliseconds(),
+		TimeLimitMs: limit.Milliseconds(),
+	})
+}
+
+// index TTL des questions servies, créé par la migration 12
+var issuedQuestionsTTL = mongo.IndexModel{
+	Keys:    bson.D{{Key: "issuedAt", Value: 1}},
+	Options: options.Index().SetName("issuedAt_1_ttl").SetExpireAfterSeconds(int32(issuedQuestionRetention.Seconds())),
+}
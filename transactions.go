@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// exécute un ensemble d'écritures multi-documents comme une seule unité
+type transactionRunner interface {
+	runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// transactions MongoDB : nécessite un replica set ou un cluster (c'est le cas d'Atlas) ;
+// fn peut être rejouée par le driver en cas d'erreur transitoire
+type mongoTransactionRunner struct {
+	client *mongo.Client
+}
+
+func (m mongoTransactionRunner) runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	opts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	}, opts)
+	return err
+}
+
+// repli NON ATOMIQUE pour les serveurs MongoDB autonomes, qui n'ont pas de transactions : les
+// transactions du processus sont sérialisées, mais rien n'est annulé si fn échoue en cours de
+// route, si bien que les écritures déjà faites restent appliquées (un score peut être ajouté à
+// users sans l'être au classement). À réserver au développement ; la commande reconcile-scores corrige
+// les écarts laissés par une transaction interrompue.
+type serializedTransactionRunner struct {
+	mu sync.Mutex
+}
+
+func (s *serializedTransactionRunner) runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(ctx)
+}
+
+// un seul runner pour que la sérialisation porte sur tout le processus
+var serializedTransactions = &serializedTransactionRunner{}
+
+var warnNonAtomicOnce sync.Once
+
+// choisit l'implémentation : MONGO_TRANSACTIONS=off pour un serveur MongoDB autonome, au prix de
+// l'atomicité
+func newTransactionRunner(client *mongo.Client) transactionRunner {
+	if os.Getenv("MONGO_TRANSACTIONS") == "off" {
+		warnNonAtomicOnce.Do(func() {
+			slog.Warn("transactions MongoDB désactivées (MONGO_TRANSACTIONS=off) : les écritures multi-documents ne sont pas atomiques")
+		})
+		return serializedTransactions
+	}
+	return mongoTransactionRunner{client: client}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestSerializedTransactionRunnerSerializes(t *testing.T) {
+	runner := &serializedTransactionRunner{}
+	var running, overlaps int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner.runInTransaction(context.Background(), func(ctx context.Context) error {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if overlaps != 0 {
+		t.Fatalf("%d transactions se sont chevauchées", overlaps)
+	}
+}
+
+// le repli n'est pas atomique : l'erreur de fn est renvoyée telle quelle et les écritures faites
+// avant l'erreur restent appliquées
+func TestSerializedTransactionRunnerDoesNotRollBack(t *testing.T) {
+	runner := &serializedTransactionRunner{}
+	failure := errors.New("échec après la première écriture")
+	var writes []string
+	err := runner.runInTransaction(context.Background(), func(ctx context.Context) error {
+		writes = append(writes, "users")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("erreur = %v, attendu %v", err, failure)
+	}
+	if len(writes) != 1 {
+		t.Fatalf("écritures = %v, attendu la première écriture conservée", writes)
+	}
+}
+
+func TestNewTransactionRunner(t *testing.T) {
+	t.Setenv("MONGO_TRANSACTIONS", "off")
+	if _, ok := newTransactionRunner(nil).(*serializedTransactionRunner); !ok {
+		t.Fatal("MONGO_TRANSACTIONS=off doit choisir le repli sérialisé")
+	}
+	t.Setenv("MONGO_TRANSACTIONS", "")
+	if _, ok := newTransactionRunner(nil).(mongoTransactionRunner); !ok {
+		t.Fatal("les transactions MongoDB doivent être utilisées par défaut")
+	}
+}
+
+// client MongoDB de test sur des bases isolées, migrées puis supprimées à la fin du test.
+// MONGO_TEST_URI doit désigner un replica set, seul à accepter les transactions ; le test est
+// ignoré sinon.
+func transactionalTestClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI non défini : test MongoDB ignoré")
+	}
+	t.Setenv("MONGO_TRANSACTIONS", "")
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hello["setName"]; !ok {
+		t.Skip("MONGO_TEST_URI ne désigne pas un replica set : test MongoDB ignoré")
+	}
+
+	previousQuizzer, previousSpotify := quizzerDatabase, spotifyDatabase
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	quizzerDatabase, spotifyDatabase = "spotTrendQuizzerTest_"+suffix, "spotifyDataTest_"+suffix
+	t.Cleanup(func() {
+		quizzerDB(client).Drop(context.Background())
+		spotifyDB(client).Drop(context.Background())
+		quizzerDatabase, spotifyDatabase = previousQuizzer, previousSpotify
+	})
+	if err := migrateUp(ctx, client, 0); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// une inscription qui échoue sur le classement ne laisse pas d'utilisateur sans entrée de classement
+func TestCreateUserRollsBack(t *testing.T) {
+	client := transactionalTestClient(t)
+	ctx := context.Background()
+	db := quizzerDB(client)
+
+	//une entrée de classement existe déjà pour cet identifiant : l'insertion dans classement
+	//échoue sur l'index unique, après celle dans users
+	if _, err := db.Collection("classement").InsertOne(ctx, UserRanking{UserID: "u1", Pseudo: "ancien"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createUser(ctx, client, User{UserID: "u1", Pseudo: "alice", Password: "hash"}); err == nil {
+		t.Fatal("inscription réussie, attendu une erreur")
+	}
+
+	if n, err := db.Collection("users").CountDocuments(ctx, bson.M{"userId": "u1"}); err != nil || n != 0 {
+		t.Fatalf("%d utilisateur(s) après l'échec (%v), attendu 0", n, err)
+	}
+	if n, err := db.Collection("classement").CountDocuments(ctx, bson.M{"userId": "u1"}); err != nil || n != 1 {
+		t.Fatalf("%d entrée(s) de classement après l'échec (%v), attendu 1", n, err)
+	}
+}
+
+// un score dont l'enregistrement échoue à la dernière étape n'est compté nulle part
+func TestRecordQuizScoreRollsBack(t *testing.T) {
+	client := transactionalTestClient(t)
+	ctx := context.Background()
+	db := quizzerDB(client)
+
+	if err := createUser(ctx, client, User{UserID: "u1", Pseudo: "alice", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	issued, err := db.Collection("issued_questions").InsertOne(ctx, issuedQuestion{
+		UserID: "u1", Type: "top_artists", IssuedAt: time.Now().UTC(), Correct: true, Points: 80,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	questionID := issued.InsertedID.(primitive.ObjectID)
+
+	//quiz_results refuse toute insertion : insertQuizRecord échoue après les mises à jour de
+	//users, classement et period_scores
+	err = db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: "quiz_results"},
+		{Key: "validator", Value: bson.M{"neverSet": bson.M{"$exists": true}}},
+		{Key: "validationAction", Value: "error"},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := QuizResult{QuestionIDs: []string{questionID.Hex()}}
+	if err := recordQuizScore(ctx, client, "u1", result); err == nil {
+		t.Fatal("score enregistré, attendu une erreur")
+	}
+
+	var user User
+	if err := db.Collection("users").FindOne(ctx, bson.M{"userId": "u1"}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ScoreTotal != 0 || user.NbDeParties != 0 || len(user.ScoreHistory) != 0 {
+		t.Errorf("utilisateur modifié après l'échec : %+v", user)
+	}
+	var entry UserRanking
+	if err := db.Collection("classement").FindOne(ctx, bson.M{"userId": "u1"}).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Score != 0 || entry.NbDeParties != 0 {
+		t.Errorf("classement modifié après l'échec : %+v", entry)
+	}
+	if n, err := db.Collection("period_scores").CountDocuments(ctx, bson.M{"userId": "u1"}); err != nil || n != 0 {
+		t.Errorf("%d score(s) de période après l'échec (%v), attendu 0", n, err)
+	}
+	if n, err := db.Collection("issued_questions").CountDocuments(ctx, bson.M{"_id": questionID, "countedAt": bson.M{"$exists": true}}); err != nil || n != 0 {
+		t.Errorf("question décomptée malgré l'échec (%v)", err)
+	}
+
+	//sans le validateur, la même partie est comptée partout
+	err = db.RunCommand(ctx, bson.D{{Key: "collMod", Value: "quiz_results"}, {Key: "validator", Value: bson.M{}}}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordQuizScore(ctx, client, "u1", result); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Collection("users").FindOne(ctx, bson.M{"userId": "u1"}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Collection("classement").FindOne(ctx, bson.M{"userId": "u1"}).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if user.ScoreTotal != 80 || entry.Score != 80 {
+		t.Errorf("scores %d (users) et %d (classement), attendu 80", user.ScoreTotal, entry.Score)
+	}
+}
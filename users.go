@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/http"
-	"sort"
 	"strings"
 	"time"
 
@@ -16,24 +15,42 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/golang-jwt/jwt"
+
+	"serveur/ranking"
 )
 
-// structure pour représenter un utilisateur
+// Schéma de la base spotTrendQuizzer (tous les champs sont en camelCase) :
+//
+//	users      : { userId, pseudo, password, scoreTotal, nbDeParties, scoreHistory, userRanking, country }
+//	classement : { userId, pseudo, scoreTotal, nbDeParties, scoreAchievedAt, country }
+//
+// userRanking, dans users, est une copie du classement autour de l'utilisateur
+// (entrées UserRanking avec leur rang) enregistrée à la fin de chaque quiz.
+// scoreAchievedAt, dans classement, est la date à laquelle le score total a changé pour la dernière fois.
+// country, facultatif, est l'un des pays de knownCountries ; il est recopié dans classement.
+
+// structure pour représenter un utilisateur (collection users)
 type User struct {
-	UserID       string        `json:"userID"`
-	Pseudo       string        `json:"pseudo"`
-	Password     string        `json:"password"`
-	ScoreTotal   int           `json:"scoreTotal"`
-	NbDeParties  int           `json:"nbDeParties"`
-	ScoreHistory []string      `json:"scoreHistory"`
-	UserRanking  []UserRanking `json:"UserRanking"`
+	UserID       string        `bson:"userId" json:"userID"`
+	Pseudo       string        `bson:"pseudo" json:"pseudo"`
+	Password     string        `bson:"password" json:"-"` // jamais renvoyé au client
+	ScoreTotal   int           `bson:"scoreTotal" json:"scoreTotal"`
+	NbDeParties  int           `bson:"nbDeParties" json:"nbDeParties"`
+	ScoreHistory []string      `bson:"scoreHistory" json:"scoreHistory"`
+	UserRanking  []UserRanking `bson:"userRanking" json:"UserRanking"`
+	Country      string        `bson:"country,omitempty" json:"country,omitempty"`
 }
 
+// entrée du classement (collection classement) ; Rank est calculé à la lecture.
+// ScoreAchievedAt et NbDeParties servent de critères de départage (voir rankingConfig).
 type UserRanking struct {
-	UserID string `bson: "userId" json: "userId"`
-	Pseudo string `bson:"pseudo" json:"pseudo"`
-	Score  int    `bson:"scoreTotal" json:"scoreTotal"`
-	Rank   int    `bson:"rank" json:"rank"`
+	UserID          string    `bson:"userId" json:"userId"`
+	Pseudo          string    `bson:"pseudo" json:"pseudo"`
+	Score           int       `bson:"scoreTotal" json:"scoreTotal"`
+	NbDeParties     int       `bson:"nbDeParties" json:"nbDeParties"`
+	ScoreAchievedAt time.Time `bson:"scoreAchievedAt" json:"scoreAchievedAt"`
+	Country         string    `bson:"country,omitempty" json:"country,omitempty"`
+	Rank            int       `bson:"rank,omitempty" json:"rank"`
 }
 
 // Clé secrète utilisée pour signer le token
@@ -54,50 +71,30 @@ func generateUniqueUserID() string {
 	return uniqueID
 }
 
-// récupère les joueurs du top 5 du classement
-func getTopPlayers(client *mongo.Client) ([]UserRanking, error) {
-	collection := client.Database("spotTrendQuizzer").Collection("classement")
+// nombre de rangs renvoyés par getTopPlayers, et nombre maximal de joueurs lus pour les obtenir
+const (
+	topPlayersRanks = 5
+	maxTopPlayers   = 50
+)
 
-	// Récupère tous les utilisateurs triés par score décroissant
-	cursor, err := collection.Find(context.Background(), bson.D{}, options.Find().SetSort(bson.D{{Key: "scoreTotal", Value: -1}}))
+// récupère les joueurs des 5 premiers rangs du classement, ex æquo compris
+// (au plus maxTopPlayers joueurs), en lisant le début de l'index du classement
+func getTopPlayers(ctx context.Context, b board) ([]UserRanking, error) {
+	players, err := findPlayers(ctx, b, bson.M{},
+		options.Find().SetSort(rankingSort(orderKeys(), false)).SetLimit(maxTopPlayers))
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.Background())
-
-	var players []UserRanking
-	if err = cursor.All(context.Background(), &players); err != nil {
-		return nil, err
-	}
-
-	//attribue les rangs aux joueurs en fonction de leur position dans la liste triée
-	currentRank := 1
-	for i := 0; i < len(players); i++ {
-		//si le score du joueur actuel est différent du score du joueur précédent,
-		// ou si c'est le premier joueur, attribuer le rang actuel
-		if i == 0 || players[i].Score != players[i-1].Score {
-			players[i].Rank = currentRank
-			currentRank++
-		} else {
-			//si le score est le même, attribuer le même rang que le joueur précédent
-			players[i].Rank = players[i-1].Rank
-		}
-	}
 
-	//conserve uniquement les meilleurs joueurs jusqu'à la limite de 5 rangs uniques
-	var topPlayers []UserRanking
-	rankSet := make(map[int]struct{})
-	for _, player := range players {
-		if len(rankSet) < 5 {
-			if _, exists := rankSet[player.Rank]; !exists {
-				rankSet[player.Rank] = struct{}{}
-				topPlayers = append(topPlayers, player)
-			}
-		} else {
+	ranks := rankingConfig.Assign(rankingEntries(players), ranking.Top)
+	topPlayers := players[:0]
+	for i, player := range players {
+		if ranks[i] > topPlayersRanks {
 			break
 		}
+		player.Rank = ranks[i]
+		topPlayers = append(topPlayers, player)
 	}
-
 	return topPlayers, nil
 }
 
@@ -121,125 +118,150 @@ func generateToken(userID string) (string, error) {
 	return tokenString, nil
 }
 
-// récupère le classement autour d'un utilisateur donné.
-func getRanking(client *mongo.Client, userID string) ([]UserRanking, error) {
-	collection := client.Database("spotTrendQuizzer").Collection("classement")
-
-	// recupere tous les users depuis collection
-	cursor, err := collection.Find(context.Background(), bson.M{})
-	if err != nil {
+// récupère le classement autour d'un utilisateur donné : le joueur le plus proche au rang
+// juste au-dessus, l'utilisateur et le joueur le plus proche au rang juste en dessous
+func getRanking(ctx context.Context, b board, userID string) ([]UserRanking, error) {
+	//trouve l'utilisateur actuel
+	var currentUser UserRanking
+	err := b.collection.FindOne(ctx, b.filter(bson.M{"userId": userID})).Decode(&currentUser)
+	if err == mongo.ErrNoDocuments {
+		return []UserRanking{}, nil
+	} else if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.Background())
 
-	var users []UserRanking
-	if err = cursor.All(context.Background(), &users); err != nil {
+	//trouve l'utilisateur juste avant et juste après l'utilisateur actuel, hors ex æquo
+	var prevUser, nextUser UserRanking
+	err = b.collection.FindOne(ctx, b.filter(aheadOf(rankingKeys(), currentUser)),
+		options.FindOne().SetSort(rankingSort(orderKeys(), true)),
+	).Decode(&prevUser)
+	if err != nil && err != mongo.ErrNoDocuments {
 		return nil, err
 	}
-
-	// trie les utilisateurs par score en ordre décroissant
-	sort.Slice(users, func(i, j int) bool {
-		return users[i].Score > users[j].Score
-	})
-
-	//attribue des rangs aux utilisateurs en fonction de leur position dans la liste triée
-	currentRank := 1
-	for i := 0; i < len(users); i++ {
-		if i == 0 || users[i].Score != users[i-1].Score {
-			users[i].Rank = currentRank
-			currentRank++
-		} else {
-			users[i].Rank = users[i-1].Rank
-		}
-	}
-
-	//trouve l'utilisateur actuel
-	var currentUser UserRanking
-	for _, user := range users {
-		if user.UserID == userID {
-			currentUser = user
-			break
-		}
+	err = b.collection.FindOne(ctx, b.filter(behind(rankingKeys(), currentUser)),
+		options.FindOne().SetSort(rankingSort(orderKeys(), false)),
+	).Decode(&nextUser)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
 	}
 
-	//trouve l'utilisateur juste avant et juste après l'utilisateur actuel
-	var prevUser, nextUser UserRanking
-	for _, user := range users {
-		if user.UserID != currentUser.UserID {
-			if user.Rank <= currentUser.Rank-1 {
-				prevUser = user
-			} else if user.Rank >= currentUser.Rank+1 {
-				nextUser = user
-				break //arrête dès que l'utilisateur suivant est trouvé
-			}
-		}
+	//le rang de l'utilisateur n'est calculé qu'une fois, celui de ses voisins en est déduit
+	start, err := rankStart(ctx, b, currentUser)
+	if err != nil {
+		return nil, err
 	}
+	currentUser.Rank = start.Rank
 
 	//créer le classement autour de l'utilisateur actuel
 	var ranking []UserRanking
 	if prevUser.Pseudo != "" {
+		if prevUser.Rank, err = neighbourRank(ctx, b, prevUser, start.Rank-1); err != nil {
+			return nil, err
+		}
 		ranking = append(ranking, prevUser)
 	}
 	ranking = append(ranking, currentUser)
 	if nextUser.Pseudo != "" {
+		if nextUser.Rank, err = neighbourRank(ctx, b, nextUser, start.Rank+1); err != nil {
+			return nil, err
+		}
 		ranking = append(ranking, nextUser)
 	}
-
 	return ranking, nil
 }
 
+// extrait et vérifie le token Bearer de la requête, puis renvoie l'ID de l'utilisateur
+func userIDFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tokenHeader := r.Header.Get("Authorization")
+	if tokenHeader == "" {
+		writeError(w, http.StatusUnauthorized, codeMissingToken, "L'en-tête Authorization est requis", nil)
+		return "", false
+	}
+
+	splitToken := strings.Split(tokenHeader, "Bearer ")
+	if len(splitToken) != 2 {
+		writeError(w, http.StatusUnauthorized, codeInvalidToken, "Token d'autorisation invalide", nil)
+		return "", false
+	}
+
+	userID, ok := userIDFromToken(splitToken[1])
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeInvalidToken, "Token d'autorisation invalide", nil)
+		return "", false
+	}
+	return userID, true
+}
+
+// vérifie le token JWT et renvoie l'identifiant de l'utilisateur qu'il désigne
+func userIDFromToken(tokenString string) (string, bool) {
+	claims := &jwt.StandardClaims{}
+
+	//vérification du token
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return "", false
+	}
+
+	return strings.TrimSpace(claims.Subject), true
+}
+
+// ajoute l'utilisateur dans les collections users et classement au sein d'une même transaction
+func createUser(ctx context.Context, client *mongo.Client, newUser User) error {
+	db := quizzerDB(client)
+	return newTransactionRunner(client).runInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := db.Collection("users").InsertOne(ctx, newUser); err != nil {
+			return err
+		}
+
+		classementEntry := UserRanking{
+			UserID:          newUser.UserID,
+			Pseudo:          newUser.Pseudo,
+			Score:           newUser.ScoreTotal, // qui est 0 pour un nouvel utilisateur
+			ScoreAchievedAt: time.Now().UTC(),
+			Country:         newUser.Country,
+		}
+		if _, err := db.Collection("classement").InsertOne(ctx, classementEntry); err != nil {
+			return fmt.Errorf("erreur lors de l'ajout de l'utilisateur à la collection de classement: %w", err)
+		}
+		return updateScoreGroups(ctx, allTimeBoard(db), nil, &classementEntry)
+	})
+}
+
 // --------------- Handler gérant les données de users ---------------------
 
 // Handler pour la requête d'inscription
 func signUpHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	var req signUpRequest
+	if !decodeJSONBody(w, r, &req, maxSignUpBodyBytes) {
 		return
 	}
 
-	var newUser User
-
-	err := json.NewDecoder(r.Body).Decode(&newUser)
-	if err != nil {
-		http.Error(w, "Erreur lors de la lecture des données JSON", http.StatusBadRequest)
+	//vérifie le pseudonyme et la robustesse du mot de passe
+	if errs := req.validate(); len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Les données d'inscription sont invalides", errs)
 		return
 	}
 
-	//vérifie si les champs sont bien remplis
-	if newUser.Pseudo == "" || newUser.Password == "" {
-		http.Error(w, "Pseudonyme et mot de passe requis", http.StatusBadRequest)
-		return
+	newUser := User{
+		Pseudo:   req.Pseudo,
+		Password: req.Password,
+		Country:  req.Country,
 	}
 
 	client, err := connectToMongo()
 	if err != nil {
-		http.Error(w, "Erreur lors de la connexion à MongoDB", http.StatusInternalServerError)
-		log.Println(err)
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
 		return
 	}
 
 	defer client.Disconnect(context.Background())
 
-	//vérifie si le pseudonyme est unique dans la bdd
-	collection := client.Database("spotTrendQuizzer").Collection("users")
-	var existingUser User
-	err = collection.FindOne(context.Background(), bson.M{"pseudo": newUser.Pseudo}).Decode(&existingUser)
-	if err == nil {
-		http.Error(w, "Le pseudonyme est déjà pris", http.StatusBadRequest)
-		return
-	} else if err != mongo.ErrNoDocuments {
-		http.Error(w, "Erreur lors de la recherche de l'utilisateur dans la base de données", http.StatusInternalServerError)
-		log.Println(err)
-		return
-	}
-
 	//génération de l'ID utilisateur unique
 	newUser.UserID = generateUniqueUserID()
-	log.Printf("token genere %s", newUser.UserID)
+	loggerFrom(r.Context()).Debug("identifiant utilisateur généré", "user_id", newUser.UserID)
 
 	//initialise le tableau de l'historique des scores avec une liste vide
 	newUser.ScoreHistory = []string{}
@@ -248,81 +270,64 @@ func signUpHandler(w http.ResponseWriter, r *http.Request) {
 	newUser.ScoreTotal = 0
 	newUser.NbDeParties = 0
 
-	//ajout du nouvel utilisateur dans la collection users
-	_, err = collection.InsertOne(context.Background(), newUser)
-	if err != nil {
-		http.Error(w, "Erreur lors de l'ajout de l'utilisateur à la base de données", http.StatusInternalServerError)
-		log.Println(err)
+	//l'unicité du pseudonyme (insensible à la casse) est garantie par l'index unique de la collection
+	err = createUser(r.Context(), client, newUser)
+	if mongo.IsDuplicateKeyError(err) {
+		writeError(w, http.StatusConflict, codePseudoTaken, "Le pseudonyme est déjà pris", nil)
 		return
-	}
-
-	classementCollection := client.Database("spotTrendQuizzer").Collection("classement")
-	classementEntry := bson.M{
-		"userId":     newUser.UserID,
-		"pseudo":     newUser.Pseudo,
-		"scoreTotal": newUser.ScoreTotal, // qui est 0 pour un nouvel utilisateur
-	}
-
-	//insère maintenant le nouvel utilisateur dans la collection classement
-	_, err = classementCollection.InsertOne(context.Background(), classementEntry)
-	if err != nil {
-		http.Error(w, "Erreur lors de l'ajout de l'utilisateur à la collection de classement", http.StatusInternalServerError)
-		log.Println(err)
+	} else if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de l'ajout de l'utilisateur à la base de données", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de l'ajout de l'utilisateur à la base de données", nil)
 		return
 	}
 
 	// Réponse de succès
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Utilisateur enregistré avec succès !"})
+	writeJSON(w, http.StatusCreated, map[string]string{"message": "Utilisateur enregistré avec succès !"})
 }
 
 // Handler pour la requête de connexion
 func signInHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
 	var signInInfo struct {
 		Pseudo   string `json:"pseudo"`
 		Password string `json:"password"`
 	}
 	err := json.NewDecoder(r.Body).Decode(&signInInfo)
 	if err != nil {
-		http.Error(w, "Erreur lors de la lecture des données JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "Erreur lors de la lecture des données JSON", nil)
 		return
 	}
 
 	client, err := connectToMongo()
 	if err != nil {
-		http.Error(w, "Erreur lors de la connexion à MongoDB", http.StatusInternalServerError)
-		log.Println(err)
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
 		return
 	}
 	defer client.Disconnect(context.Background())
 
-	//recherche l'utilisateur dans la base de données
-	collection := client.Database("spotTrendQuizzer").Collection("users")
+	//recherche l'utilisateur par pseudonyme sans tenir compte de la casse ;
+	//le mot de passe est comparé ensuite pour rester sensible à la casse
+	collection := quizzerDB(client).Collection("users")
 	var user User
-	err = collection.FindOne(context.Background(), bson.M{"pseudo": signInInfo.Pseudo, "password": signInInfo.Password}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Identifiant ou mot de passe incorrect", http.StatusUnauthorized)
-		} else {
-			http.Error(w, "Erreur lors de la recherche de l'utilisateur dans la base de données", http.StatusInternalServerError)
-			log.Println(err)
-		}
+	err = collection.FindOne(
+		context.Background(),
+		bson.M{"pseudo": signInInfo.Pseudo},
+		options.FindOne().SetCollation(pseudoCollation),
+	).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		loggerFrom(r.Context()).Error("erreur lors de la recherche de l'utilisateur dans la base de données", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la recherche de l'utilisateur dans la base de données", nil)
+		return
+	}
+	if err == mongo.ErrNoDocuments || subtle.ConstantTimeCompare([]byte(user.Password), []byte(signInInfo.Password)) != 1 {
+		writeError(w, http.StatusUnauthorized, codeInvalidCredentials, "Identifiant ou mot de passe incorrect", nil)
 		return
 	}
 
 	tokenString, err := generateToken(user.UserID)
 	if err != nil {
-		http.Error(w, "Erreur lors de la creation de token", http.StatusInternalServerError)
-		log.Println(err)
+		loggerFrom(r.Context()).Error("erreur lors de la creation de token", "error", err)
+		writeError(w, http.StatusInternalServerError, codeInternalError, "Erreur lors de la creation de token", nil)
 		return
 	}
 
@@ -333,126 +338,98 @@ func signInHandler(w http.ResponseWriter, r *http.Request) {
 		Token: tokenString,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
 
 // Handler pour récupérer les 5 premiers top players for homepage
 func topPlayersHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	var errs []fieldError
+	p, ferr := periodFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	country, ferr := countryFromRequest(r)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	perCountry := r.URL.Query().Get("perCountry") == "true"
+	if perCountry && country != "" {
+		errs = append(errs, fieldError{Field: "perCountry", Code: "exclusive", Message: "perCountry et country ne peuvent pas être utilisés ensemble"})
+	}
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Paramètres invalides", errs)
 		return
 	}
 
 	client, err := connectToMongo()
 	if err != nil {
-		http.Error(w, "Erreur lors de la connexion à la base de données", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à la base de données", nil)
 		return
 	}
 	defer client.Disconnect(context.Background())
 
-	//obtient le top5 les joueurs du classement
-	topPlayers, err := getTopPlayers(client)
+	//obtient le top5 les joueurs du classement de la période, ou de chaque pays avec perCountry=true
+	var topPlayers interface{}
+	p, err = resolvePeriod(r.Context(), quizzerDB(client), p, time.Now())
+	if err == nil && perCountry {
+		topPlayers, err = getTopPlayersByCountry(r.Context(), periodBoard(quizzerDB(client), p))
+	} else if err == nil {
+		topPlayers, err = getTopPlayers(r.Context(), periodBoard(quizzerDB(client), p).inCountry(country))
+	}
 	if err != nil {
-		http.Error(w, "Erreur lors de la récupération des joueurs", http.StatusInternalServerError)
+		loggerFrom(r.Context()).Error("erreur lors de la récupération des joueurs", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération des joueurs", nil)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(topPlayers)
-	if err != nil {
-		http.Error(w, "Erreur lors de l'encodage des joueurs en JSON", http.StatusInternalServerError)
-	}
+	writeJSON(w, http.StatusOK, topPlayers)
 }
 
 // Handler pour récupérer les informations de l'utilisateur
 func userInfoHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	userID, ok := userIDFromRequest(w, r)
+	if !ok {
 		return
 	}
-	log.Printf("Received token: %s", r.Header.Get("Authorization"))
 
-	tokenHeader := r.Header.Get("Authorization")
-	if tokenHeader == "" {
-		http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+	client, err := connectToMongo()
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la connexion à MongoDB", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la connexion à MongoDB", nil)
 		return
 	}
+	defer client.Disconnect(context.Background())
 
-	splitToken := strings.Split(tokenHeader, "Bearer ")
-	if len(splitToken) != 2 {
-		http.Error(w, "Invalid Authorization token", http.StatusUnauthorized)
+	//trouve l'utilisateur dans la base de données
+	collection := quizzerDB(client).Collection("users")
+	var user User
+	err = collection.FindOne(context.Background(), bson.M{"userId": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			writeError(w, http.StatusNotFound, codeUserNotFound, "Utilisateur introuvable", nil)
+		} else {
+			loggerFrom(r.Context()).Error("erreur lors de la recherche de l'utilisateur dans la base de données", "error", err)
+			writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la recherche de l'utilisateur dans la base de données", nil)
+		}
 		return
 	}
-
-	tokenString := splitToken[1]
-	claims := &jwt.StandardClaims{}
-
-	//vérification du token
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid Authorization token", http.StatusUnauthorized)
+	//obtient le classement de l'utilisateur
+	ranking, err := getRanking(r.Context(), allTimeBoard(quizzerDB(client)), userID)
+	if err != nil {
+		loggerFrom(r.Context()).Error("erreur lors de la récupération du classement de l'utilisateur", "error", err)
+		writeError(w, http.StatusInternalServerError, codeDatabaseError, "Erreur lors de la récupération du classement de l'utilisateur", nil)
 		return
 	}
-	userID := strings.TrimSpace(claims.Subject)
-
-	if _, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 
-		client, err := connectToMongo()
-		if err != nil {
-			http.Error(w, "Error connecting to MongoDB", http.StatusInternalServerError)
-			log.Println(err)
-			return
-		}
-		defer client.Disconnect(context.Background())
-
-		//trouve l'utilisateur dans la base de données
-		collection := client.Database("spotTrendQuizzer").Collection("users")
-		var user User
-		err = collection.FindOne(context.Background(), bson.M{"userid": userID}).Decode(&user)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				http.Error(w, "User not found", http.StatusNotFound)
-				return
-			} else {
-				http.Error(w, "Error searching user in the database", http.StatusInternalServerError)
-				log.Println(err)
-				return
-			}
-		}
-		//obtient le classement de l'utilisateur
-		ranking, err := getRanking(client, userID)
-		if err != nil {
-			http.Error(w, "Error retrieving user ranking", http.StatusInternalServerError)
-			log.Println(err)
-			return
-		}
-
-		//prépare une structure de réponse combinée qui comprend les informations de l'utilisateur et son classement
-		response := struct {
-			UserInfo User          `json:"userInfo"`
-			Ranking  []UserRanking `json:"ranking"`
-		}{
-			UserInfo: user,
-			Ranking:  ranking,
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
-	} else {
-		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+	//prépare une structure de réponse combinée qui comprend les informations de l'utilisateur et son classement
+	response := struct {
+		UserInfo User          `json:"userInfo"`
+		Ranking  []UserRanking `json:"ranking"`
+	}{
+		UserInfo: user,
+		Ranking:  ranking,
 	}
+
+	writeJSON(w, http.StatusOK, response)
 }
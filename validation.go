@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// taille maximale acceptée pour le corps JSON d'une inscription
+const maxSignUpBodyBytes = 4 << 10
+
+const (
+	pseudoMinLength   = 3
+	pseudoMaxLength   = 20
+	passwordMinLength = 8
+	passwordMaxLength = 72
+)
+
+// lettres, chiffres, '_', '-' et '.', en commençant par une lettre ou un chiffre
+var pseudoPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// pseudonymes réservés à l'application ou prêtant à confusion
+var reservedPseudos = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true, "moderator": true,
+	"support": true, "spottrend": true, "spotify": true, "api": true, "me": true,
+	"null": true, "undefined": true,
+}
+
+// mots de passe trop courants pour être acceptés
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "12345678": true, "123456789": true, "1234567890": true,
+	"azerty123": true, "qwerty123": true, "motdepasse": true, "motdepasse1": true, "iloveyou1": true,
+}
+
+// corps de la requête d'inscription : seuls le pseudonyme, le mot de passe et le pays
+// (facultatif) sont acceptés
+type signUpRequest struct {
+	Pseudo   string `json:"pseudo"`
+	Password string `json:"password"`
+	Country  string `json:"country"`
+}
+
+// erreur de validation portant sur un champ précis de la requête
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// vérifie le pseudonyme, la politique de mot de passe et le pays, qui est normalisé
+func (req *signUpRequest) validate() []fieldError {
+	var errs []fieldError
+
+	pseudoLength := utf8.RuneCountInString(req.Pseudo)
+	switch {
+	case req.Pseudo == "":
+		errs = append(errs, fieldError{"pseudo", "required", "Le pseudonyme est requis"})
+	case pseudoLength < pseudoMinLength || pseudoLength > pseudoMaxLength:
+		errs = append(errs, fieldError{"pseudo", "length", fmt.Sprintf("Le pseudonyme doit contenir entre %d et %d caractères", pseudoMinLength, pseudoMaxLength)})
+	case !pseudoPattern.MatchString(req.Pseudo):
+		errs = append(errs, fieldError{"pseudo", "charset", "Le pseudonyme ne peut contenir que des lettres, des chiffres, '_', '-' et '.', et doit commencer par une lettre ou un chiffre"})
+	case reservedPseudos[strings.ToLower(req.Pseudo)]:
+		errs = append(errs, fieldError{"pseudo", "reserved", "Ce pseudonyme est réservé"})
+	}
+
+	passwordLength := utf8.RuneCountInString(req.Password)
+	hasLetter := strings.IndexFunc(req.Password, unicode.IsLetter) >= 0
+	hasDigit := strings.IndexFunc(req.Password, unicode.IsDigit) >= 0
+	switch {
+	case req.Password == "":
+		errs = append(errs, fieldError{"password", "required", "Le mot de passe est requis"})
+	case passwordLength < passwordMinLength || passwordLength > passwordMaxLength:
+		errs = append(errs, fieldError{"password", "length", fmt.Sprintf("Le mot de passe doit contenir entre %d et %d caractères", passwordMinLength, passwordMaxLength)})
+	case !hasLetter || !hasDigit:
+		errs = append(errs, fieldError{"password", "weak", "Le mot de passe doit contenir au moins une lettre et un chiffre"})
+	case commonPasswords[strings.ToLower(req.Password)]:
+		errs = append(errs, fieldError{"password", "common", "Ce mot de passe est trop courant"})
+	case req.Pseudo != "" && strings.Contains(strings.ToLower(req.Password), strings.ToLower(req.Pseudo)):
+		errs = append(errs, fieldError{"password", "contains_pseudo", "Le mot de passe ne doit pas contenir le pseudonyme"})
+	}
+
+	country, ferr := validateCountry("country", req.Country)
+	if ferr != nil {
+		errs = append(errs, *ferr)
+	}
+	req.Country = country
+
+	return errs
+}
+
+// décode un unique objet JSON en limitant la taille du corps et en refusant les champs inconnus ;
+// en cas d'erreur, la réponse est déjà écrite et false est renvoyé
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("le corps doit contenir un seul objet JSON")
+	}
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Le corps de la requête est trop volumineux", map[string]int64{"maxBytes": maxBytesErr.Limit})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeError(w, http.StatusBadRequest, codeValidationFailed, "Champ inconnu dans la requête", []fieldError{{field, "unknown", "Ce champ n'est pas accepté"}})
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "Erreur lors de la lecture des données JSON", nil)
+	}
+	return false
+}